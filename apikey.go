@@ -0,0 +1,145 @@
+package tigerblood
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// apiKeyHashPrefix prefixes hashed API keys in key specs
+const apiKeyHashPrefix = "sha256:"
+
+// apiKeySaltSize is the number of random bytes an API key is salted with
+const apiKeySaltSize = 16
+
+// APIKey is a salted hash of an API key secret. The key is accepted between NotBefore and
+// NotAfter, either of which may be zero to not limit the validity in that direction, so that
+// an identity can have several keys during a rotation.
+type APIKey struct {
+	Salt      []byte
+	Hash      []byte
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// HashAPIKey returns an APIKey for the secret with a random salt
+func HashAPIKey(secret string) (APIKey, error) {
+	salt := make([]byte, apiKeySaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return APIKey{Salt: salt, Hash: hashAPIKey(salt, secret)}, nil
+}
+
+func hashAPIKey(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// ParseAPIKey parses an API key spec. A spec is a hashed key as returned by APIKey.String, or
+// a plaintext key which is hashed with a random salt, optionally followed by space separated
+// not_before=<RFC 3339 time> and not_after=<RFC 3339 time> fields.
+func ParseAPIKey(spec string) (APIKey, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return APIKey{}, fmt.Errorf("Empty API key")
+	}
+	var (
+		key APIKey
+		err error
+	)
+	if strings.HasPrefix(fields[0], apiKeyHashPrefix) {
+		parts := strings.Split(strings.TrimPrefix(fields[0], apiKeyHashPrefix), ":")
+		if len(parts) != 2 {
+			return key, fmt.Errorf("Invalid API key hash")
+		}
+		key.Salt, err = base64.RawStdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return key, fmt.Errorf("Invalid API key salt: %s", err)
+		}
+		key.Hash, err = base64.RawStdEncoding.DecodeString(parts[1])
+		if err != nil || len(key.Hash) != sha256.Size {
+			return key, fmt.Errorf("Invalid API key hash")
+		}
+	} else {
+		key, err = HashAPIKey(fields[0])
+		if err != nil {
+			return key, err
+		}
+	}
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return key, fmt.Errorf("Invalid API key field %q", f)
+		}
+		t, err := time.Parse(time.RFC3339, kv[1])
+		if err != nil {
+			return key, fmt.Errorf("Invalid API key %s time: %s", kv[0], err)
+		}
+		switch kv[0] {
+		case "not_before":
+			key.NotBefore = t
+		case "not_after":
+			key.NotAfter = t
+		default:
+			return key, fmt.Errorf("Invalid API key field %q", f)
+		}
+	}
+	return key, nil
+}
+
+// String returns the hashed key in the form accepted by ParseAPIKey, without validity times
+func (k APIKey) String() string {
+	return apiKeyHashPrefix + base64.RawStdEncoding.EncodeToString(k.Salt) + ":" +
+		base64.RawStdEncoding.EncodeToString(k.Hash)
+}
+
+// Matches returns true if secret is the key, comparing hashes in constant time
+func (k APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare(hashAPIKey(k.Salt, secret), k.Hash) == 1
+}
+
+// ActiveAt returns true if the key is accepted at t
+func (k APIKey) ActiveAt(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) && (k.NotAfter.IsZero() || t.Before(k.NotAfter))
+}
+
+// ParseAPIKeyCredentials parses API key credentials from configuration, a map of identifiers to
+// either a single key spec or a list of key specs (see ParseAPIKey)
+func ParseAPIKeyCredentials(credentials map[string]interface{}) (map[string][]APIKey, error) {
+	keys := make(map[string][]APIKey, len(credentials))
+	for id, v := range credentials {
+		var specs []string
+		switch v := v.(type) {
+		case string:
+			specs = []string{v}
+		case []interface{}:
+			for _, s := range v {
+				spec, ok := s.(string)
+				if !ok {
+					return nil, fmt.Errorf("Invalid API key for %s: %v", id, s)
+				}
+				specs = append(specs, spec)
+			}
+		case []string:
+			specs = v
+		default:
+			return nil, fmt.Errorf("Invalid API keys for %s: %v", id, v)
+		}
+		for _, spec := range specs {
+			key, err := ParseAPIKey(spec)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid API key for %s: %s", id, err)
+			}
+			keys[id] = append(keys[id], key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,99 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	key, err := HashAPIKey("valid_key")
+	assert.Nil(t, err)
+	assert.True(t, key.Matches("valid_key"))
+	assert.False(t, key.Matches("valid_key2"))
+	assert.False(t, key.Matches(""))
+
+	// The same key hashes differently with another salt
+	other, err := HashAPIKey("valid_key")
+	assert.Nil(t, err)
+	assert.NotEqual(t, key.String(), other.String())
+
+	parsed, err := ParseAPIKey(key.String())
+	assert.Nil(t, err)
+	assert.Equal(t, key, parsed)
+}
+
+func TestParseAPIKey(t *testing.T) {
+	key, err := ParseAPIKey("plaintext_key not_before=2026-01-01T00:00:00Z not_after=2026-02-01T00:00:00Z")
+	assert.Nil(t, err)
+	assert.True(t, key.Matches("plaintext_key"))
+	assert.False(t, key.ActiveAt(time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, key.ActiveAt(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, key.ActiveAt(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)))
+
+	key, err = ParseAPIKey("plaintext_key")
+	assert.Nil(t, err)
+	assert.True(t, key.ActiveAt(time.Now()))
+
+	for _, spec := range []string{
+		"",
+		"sha256:abc",
+		"sha256:!!!:abc",
+		"sha256:c2FsdA:c2hvcnQ",
+		"key not_before=yesterday",
+		"key expires=2026-01-01T00:00:00Z",
+		"key not_after",
+	} {
+		_, err = ParseAPIKey(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestParseAPIKeyCredentials(t *testing.T) {
+	hashed, err := HashAPIKey("key2")
+	assert.Nil(t, err)
+	keys, err := ParseAPIKeyCredentials(map[string]interface{}{
+		"fxa":   "key1",
+		"addon": []interface{}{hashed.String() + " not_after=2026-01-01T00:00:00Z", "key3"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, keys["fxa"], 1)
+	assert.Len(t, keys["addon"], 2)
+	assert.True(t, keys["addon"][0].Matches("key2"))
+	assert.True(t, keys["addon"][1].Matches("key3"))
+
+	_, err = ParseAPIKeyCredentials(map[string]interface{}{"fxa": 1})
+	assert.NotNil(t, err)
+	_, err = ParseAPIKeyCredentials(map[string]interface{}{"fxa": []interface{}{"key1", 2}})
+	assert.NotNil(t, err)
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	now := time.Now()
+	old, err := HashAPIKey("old_key")
+	assert.Nil(t, err)
+	old.NotAfter = now.Add(-time.Minute)
+	current, err := HashAPIKey("current_key")
+	assert.Nil(t, err)
+	next, err := HashAPIKey("next_key")
+	assert.Nil(t, err)
+	next.NotBefore = now.Add(time.Hour)
+	SetAPIKeys(map[string][]APIKey{"fxa": {old, current, next}})
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
+
+	for key, code := range map[string]int{
+		"old_key":     http.StatusUnauthorized,
+		"current_key": http.StatusOK,
+		"next_key":    http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", "http://foo.bar/", nil)
+		req.Header.Set("Authorization", "APIKey "+key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code, key)
+	}
+}
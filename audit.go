@@ -0,0 +1,148 @@
+package tigerblood
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Audit actions recorded for reputation writes
+const (
+	AuditActionSet    = "set"
+	AuditActionDelete = "delete"
+)
+
+// AuditEntry records a single write to a reputation entry. The old and new fields are nil if
+// the entry did not exist before or after the write.
+type AuditEntry struct {
+	ID            int64     // Entry ID, used as the pagination cursor
+	IP            string    // The IP address or network that was written
+	Action        string    // The kind of write, e.g., AuditActionSet
+	OldReputation *uint     // Reputation before the write
+	NewReputation *uint     // Reputation after the write
+	OldReviewed   *bool     // Reviewed flag before the write
+	NewReviewed   *bool     // Reviewed flag after the write
+	Actor         string    // ID of the credential that made the write, if authenticated
+	SourceIP      string    // Address the request was received from
+	Created       time.Time // When the write was made
+}
+
+// AuditFilter selects audit entries in SelectAuditEntries. Zero valued fields are ignored.
+type AuditFilter struct {
+	IP     string    // Only entries for this address or network, or contained in it
+	Actor  string    // Only entries made by this credential ID
+	Since  time.Time // Only entries made at or after this time
+	Before int64     // Only entries with an ID lower than this, for pagination
+}
+
+// selectReputationEntryForUpdate returns the reputation entry for exactly ip, locking it for the
+// rest of tx, or nil if there is no such entry
+func selectReputationEntryForUpdate(tx *sql.Tx, ip string) (*ReputationEntry, error) {
+	var entry ReputationEntry
+	err := tx.QueryRow("SELECT ip, reputation, reviewed FROM reputation WHERE ip = $1 FOR UPDATE",
+		ip).Scan(&entry.IP, &entry.Reputation, &entry.Reviewed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// WithAudit runs write in a transaction and records an AuditEntry with the reputation entry for
+// ip before and after the write. If write returns an error the transaction is rolled back,
+// nothing is recorded and the error is returned.
+func (db DB) WithAudit(ip string, action string, actor string, sourceIP string,
+	write func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	old, err := selectReputationEntryForUpdate(tx, ip)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = write(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	cur, err := selectReputationEntryForUpdate(tx, ip)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var oldRep, newRep sql.NullInt64
+	var oldRev, newRev sql.NullBool
+	if old != nil {
+		oldRep = sql.NullInt64{Int64: int64(old.Reputation), Valid: true}
+		oldRev = sql.NullBool{Bool: old.Reviewed, Valid: true}
+	}
+	if cur != nil {
+		newRep = sql.NullInt64{Int64: int64(cur.Reputation), Valid: true}
+		newRev = sql.NullBool{Bool: cur.Reviewed, Valid: true}
+	}
+	_, err = tx.Exec("INSERT INTO audit (ip, action, old_reputation, new_reputation, "+
+		"old_reviewed, new_reviewed, actor, source_ip) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)",
+		ip, action, oldRep, newRep, oldRev, newRev, actor, sourceIP)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SelectAuditEntries returns audit entries matching filter, newest first, returning at most
+// limit entries
+func (db DB) SelectAuditEntries(filter AuditFilter, limit int) (ret []AuditEntry, err error) {
+	var ip sql.NullString
+	if filter.IP != "" {
+		ip = sql.NullString{String: filter.IP, Valid: true}
+	}
+	var since pq.NullTime
+	if !filter.Since.IsZero() {
+		since = pq.NullTime{Time: filter.Since, Valid: true}
+	}
+	rows, err := db.Query("SELECT id, ip, action, old_reputation, new_reputation, old_reviewed, "+
+		"new_reviewed, actor, source_ip, created FROM audit "+
+		"WHERE ($1::iprange IS NULL OR ip <<= $1) AND ($2 = '' OR actor = $2) "+
+		"AND ($3::timestamptz IS NULL OR created >= $3) AND ($4 = 0 OR id < $4) "+
+		"ORDER BY id DESC LIMIT $5", ip, filter.Actor, since, filter.Before, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			ent            AuditEntry
+			oldRep, newRep sql.NullInt64
+			oldRev, newRev sql.NullBool
+			actor          sql.NullString
+		)
+		err = rows.Scan(&ent.ID, &ent.IP, &ent.Action, &oldRep, &newRep, &oldRev, &newRev,
+			&actor, &ent.SourceIP, &ent.Created)
+		if err != nil {
+			return
+		}
+		if oldRep.Valid {
+			v := uint(oldRep.Int64)
+			ent.OldReputation = &v
+		}
+		if newRep.Valid {
+			v := uint(newRep.Int64)
+			ent.NewReputation = &v
+		}
+		if oldRev.Valid {
+			ent.OldReviewed = &oldRev.Bool
+		}
+		if newRev.Valid {
+			ent.NewReviewed = &newRev.Bool
+		}
+		ent.Actor = actor.String
+		ret = append(ret, ent)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,98 @@
+package tigerblood
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditReputationWrites(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+
+	SetDB(db)
+	SetMaxEntries(100)
+	SetAPIKeyCredentials(map[string]string{"admin": "key1", "analyst": "key2"})
+	assert.Nil(t, SetCredentialScopes(map[string]string{"admin": "admin", "analyst": "admin"}))
+	defer SetCredentialScopes(nil)
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+	h := HandleWithMiddleware(NewRouter(), []Middleware{RequireAuth()})
+
+	do := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "10.1.1.1:4000"
+		req.Header.Set("Authorization", "APIKey "+key)
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+	type auditPage struct {
+		Entries []AuditEntry
+		Next    int64
+	}
+	get := func(path string) auditPage {
+		var page auditPage
+		recorder := do("GET", path, "", "key2")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		return page
+	}
+
+	start := time.Now().Add(-time.Second)
+	assert.Equal(t, http.StatusOK, do("PUT", "/192.168.0.1", `{"Reputation": 50}`, "key1").Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/192.168.0.1", `{"Reputation": 0, "Reviewed": true}`, "key2").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/192.168.0.1", "", "key1").Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/10.0.0.0/8", `{"Reputation": 20}`, "key1").Code)
+
+	page := get("/audit")
+	assert.Equal(t, 4, len(page.Entries))
+	assert.Equal(t, int64(0), page.Next)
+
+	page = get("/audit?ip=192.168.0.1")
+	assert.Equal(t, 3, len(page.Entries))
+	del, set, create := page.Entries[0], page.Entries[1], page.Entries[2]
+	assert.Equal(t, AuditActionDelete, del.Action)
+	assert.Equal(t, uint(0), *del.OldReputation)
+	assert.Nil(t, del.NewReputation)
+	assert.Equal(t, "admin", del.Actor)
+	assert.Equal(t, "10.1.1.1", del.SourceIP)
+	assert.Equal(t, AuditActionSet, set.Action)
+	assert.Equal(t, uint(50), *set.OldReputation)
+	assert.Equal(t, uint(0), *set.NewReputation)
+	assert.Equal(t, false, *set.OldReviewed)
+	assert.Equal(t, true, *set.NewReviewed)
+	assert.Equal(t, "analyst", set.Actor)
+	assert.Nil(t, create.OldReputation)
+	assert.Nil(t, create.OldReviewed)
+	assert.Equal(t, uint(50), *create.NewReputation)
+
+	page = get("/audit?actor=analyst")
+	assert.Equal(t, 1, len(page.Entries))
+	page = get("/audit?ip=10.0.0.0/8&actor=admin")
+	assert.Equal(t, 1, len(page.Entries))
+	page = get("/audit?since=" + start.UTC().Format(time.RFC3339))
+	assert.Equal(t, 4, len(page.Entries))
+	page = get("/audit?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	assert.Equal(t, 0, len(page.Entries))
+
+	page = get("/audit?limit=3")
+	assert.Equal(t, 3, len(page.Entries))
+	assert.NotEqual(t, int64(0), page.Next)
+	page = get(fmt.Sprintf("/audit?limit=3&before=%d", page.Next))
+	assert.Equal(t, 1, len(page.Entries))
+
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/audit?since=yesterday", "", "key2").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/audit?ip=foo", "", "key2").Code)
+
+	assert.Nil(t, db.Close())
+}
@@ -1,15 +1,20 @@
 package tigerblood
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
+	"go.mozilla.org/hawk"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Bits used in authentication modes bitmask
 const (
 	AuthEnableHawk = 1 << iota
 	AuthEnableAPIKey
+	AuthEnableMTLS
 )
 
 const (
@@ -18,17 +23,40 @@ const (
 	AuthRequestHawk
 )
 
+type contextKey int
+
+const (
+	// principalContextKey is the request context key for the authenticated credential ID
+	principalContextKey contextKey = iota
+	// summaryContextKey is the request context key for the request summary
+	summaryContextKey
+)
+
+// PrincipalFromContext returns the credential ID RequireAuth authenticated the request with,
+// or an empty string if the request was not authenticated
+func PrincipalFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(principalContextKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
 // authmodes is a bit mask indicating authentication modes to support and influences the
 // behavior of the RequireAuth handler.
 var authModes int
 
 var hawkData *HawkData
 var apiKeyData *APIKeyData
+var mtlsData *MTLSData
+
+// credentialsLock guards hawkData, apiKeyData, mtlsData and credentialScopes, which can be replaced while
+// requests are served when credentials are reloaded
+var credentialsLock sync.RWMutex
 
 // APIKeyData is configuration data representing valid API authentication keys, where
-// the key is just an identifier and the value is the actual secret.
+// the key is just an identifier and the value is the hashed keys accepted for it.
 type APIKeyData struct {
-	credentials map[string]string
+	keys map[string][]APIKey
 }
 
 // SetAuthMask sets the forms of authentication tigerblood will accept for requests. mask
@@ -40,21 +68,51 @@ func SetAuthMask(mask int) {
 
 // SetHawkCredentials configures the credentials to be used for hawk authentication
 func SetHawkCredentials(credentials map[string]string) {
-	hawkData = NewHawkData(credentials)
+	d := NewHawkData(credentials)
+	credentialsLock.Lock()
+	hawkData = d
+	credentialsLock.Unlock()
 }
 
-// SetAPIKeyCredentials configures the credentials to be used for API key authentication
+// SetAPIKeyCredentials configures the credentials to be used for API key authentication from
+// a map of identifiers to plaintext keys
 func SetAPIKeyCredentials(credentials map[string]string) {
-	apiKeyData = NewAPIKeyData(credentials)
+	d := NewAPIKeyData(credentials)
+	credentialsLock.Lock()
+	apiKeyData = d
+	credentialsLock.Unlock()
+}
+
+// SetAPIKeys configures the keys to be used for API key authentication, by identifier
+func SetAPIKeys(keys map[string][]APIKey) {
+	credentialsLock.Lock()
+	apiKeyData = &APIKeyData{keys: keys}
+	credentialsLock.Unlock()
 }
 
-// NewAPIKeyData returns API key config data from a map of API key credentials
+// NewAPIKeyData returns API key config data from a map of API key credentials, hashing each
+// plaintext key
 func NewAPIKeyData(secrets map[string]string) *APIKeyData {
+	keys := make(map[string][]APIKey, len(secrets))
+	for id, secret := range secrets {
+		key, err := HashAPIKey(secret)
+		if err != nil {
+			panic(err)
+		}
+		keys[id] = []APIKey{key}
+	}
 	return &APIKeyData{
-		credentials: secrets,
+		keys: keys,
 	}
 }
 
+// currentCredentials returns the hawk, API key and mTLS credentials in use
+func currentCredentials() (*HawkData, *APIKeyData, *MTLSData) {
+	credentialsLock.RLock()
+	defer credentialsLock.RUnlock()
+	return hawkData, apiKeyData, mtlsData
+}
+
 func getAuthRequestType(h string) int {
 	if strings.HasPrefix(h, "Hawk ") {
 		return AuthRequestHawk
@@ -81,19 +139,39 @@ func RequireAuth() Middleware {
 				return
 			}
 
-			success := false
+			var (
+				id       string
+				success  bool
+				hawkAuth *hawk.Auth
+			)
+			hd, ad, md := currentCredentials()
 			authtype := getAuthRequestType(r.Header.Get("Authorization"))
 			if (authModes&AuthEnableAPIKey != 0) && authtype == AuthRequestAPIKey {
-				success = APIKeyAuth(r, apiKeyData)
+				id, success = authenticateAPIKey(r, ad)
 			} else if authModes&AuthEnableHawk != 0 && authtype == AuthRequestHawk {
-				success = HawkAuth(r, hawkData)
+				hawkAuth, success = authenticateHawk(r, hd)
+				if success {
+					id = hawkAuth.Credentials.ID
+				}
+			} else if authModes&AuthEnableMTLS != 0 && r.Header.Get("Authorization") == "" {
+				// Requests without an Authorization header are authenticated by their client
+				// certificate
+				id, success = authenticateMTLS(r, md)
 			}
 			if !success {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
-			// Authentication successful, continue
+			// Authentication successful, continue with the credential ID on the context
+			setRequestPrincipal(r.Context(), id)
+			r = r.WithContext(context.WithValue(r.Context(), principalContextKey, id))
+			if hawkAuth != nil && hawkSignResponses {
+				sw := &hawkResponseWriter{ResponseWriter: w, auth: hawkAuth}
+				h.ServeHTTP(sw, r)
+				sw.sign()
+				return
+			}
 			h.ServeHTTP(w, r)
 		})
 	}
@@ -101,19 +179,34 @@ func RequireAuth() Middleware {
 
 // APIKeyAuth authenticates API key based requests, returns true if successful
 func APIKeyAuth(r *http.Request, m *APIKeyData) bool {
+	_, ok := authenticateAPIKey(r, m)
+	return ok
+}
+
+// authenticateAPIKey authenticates API key based requests, returning the key identifier and
+// true if successful
+func authenticateAPIKey(r *http.Request, m *APIKeyData) (string, bool) {
 	hdr := r.Header.Get("Authorization")
 	if hdr == "" {
-		log.WithFields(log.Fields{"errno": APIKeyNotSpecified}).Warnf("apikey: no key specified")
-		return false
+		requestLog(r, APIKeyNotSpecified).Warnf("apikey: no key specified")
+		return "", false
 	}
 
 	hdr = strings.TrimPrefix(hdr, "APIKey ")
-	for k, v := range m.credentials {
-		if hdr == v {
+	now := time.Now()
+	for k, keys := range m.keys {
+		for _, key := range keys {
+			if !key.Matches(hdr) {
+				continue
+			}
+			if !key.ActiveAt(now) {
+				requestLog(r, APIKeyInactive).Warnf("apikey: key for %s is not active", k)
+				return "", false
+			}
 			log.WithFields(log.Fields{"id": k}).Infof("apikey: accepted request")
-			return true
+			return k, true
 		}
 	}
-	log.WithFields(log.Fields{"errno": APIKeyInvalid}).Warnf("apikey: invalid key specified")
-	return false
+	requestLog(r, APIKeyInvalid).Warnf("apikey: invalid key specified")
+	return "", false
 }
@@ -262,3 +262,31 @@ func TestUnknownAuthorizationMethod(t *testing.T) {
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
 }
+
+func TestAuthPrincipalOnContext(t *testing.T) {
+	var principal string
+	principalHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	SetAPIKeyCredentials(map[string]string{"test": "valid_key", "test2": "valid_key2"})
+	SetAuthMask(AuthEnableAPIKey)
+	handler := HandleWithMiddleware(principalHandler, []Middleware{RequireAuth()})
+
+	req, err := http.NewRequest("GET", "http://foo.bar/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "APIKey valid_key2")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "test2", principal)
+
+	principal = "unset"
+	SetAuthMask(0)
+	req, err = http.NewRequest("GET", "http://foo.bar/", nil)
+	assert.Nil(t, err)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "", principal)
+}
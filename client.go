@@ -5,24 +5,65 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"go.mozilla.org/hawk"
 )
 
 var (
-	ClientUnexpectedGETStatusError  = errors.New("Unexpected HTTP status from GET")
-	ClientUnexpectedPUTStatusError  = errors.New("Unexpected HTTP Status from PUT")
-	ClientUnexpectedPOSTStatusError = errors.New("Unexpected HTTP Status from POST")
+	ClientUnexpectedGETStatusError    = errors.New("Unexpected HTTP status from GET")
+	ClientUnexpectedPUTStatusError    = errors.New("Unexpected HTTP Status from PUT")
+	ClientUnexpectedPOSTStatusError   = errors.New("Unexpected HTTP Status from POST")
+	ClientUnexpectedDELETEStatusError = errors.New("Unexpected HTTP Status from DELETE")
+	ClientMissingPayloadHashError     = errors.New("Response signature does not cover the payload")
+	ClientInvalidPayloadHashError     = errors.New("Response payload does not match its signature")
 )
 
+// ClientExceptedError is returned when a reputation could not be read or set because an
+// exception covers the address
+type ClientExceptedError struct {
+	Lookup ReputationLookup
+}
+
+func (e ClientExceptedError) Error() string {
+	if e.Lookup.Exception == nil {
+		return fmt.Sprintf("%s is covered by an exception", e.Lookup.IP)
+	}
+	return fmt.Sprintf("%s is covered by exception %s created by %s", e.Lookup.IP,
+		e.Lookup.Exception.IP, e.Lookup.Exception.Creator)
+}
+
+// exceptedError returns a ClientExceptedError if the response body reports that an exception
+// covers the address, otherwise err
+func exceptedError(resp *http.Response, err error) error {
+	buf, rerr := ioutil.ReadAll(resp.Body)
+	if rerr != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	var l ReputationLookup
+	if json.Unmarshal(buf, &l) != nil || l.Status != LookupExcepted {
+		return err
+	}
+	return ClientExceptedError{l}
+}
+
 // Client is an http.Client for the tigerblood service
 type Client struct {
 	*http.Client
 	*hawk.Credentials
 	URL string
+	// VerifyResponses requires responses to be signed with a Hawk Server-Authorization header
+	// covering the payload. Requests whose response is not signed or does not match its
+	// signature fail with an error and no response. Streamed responses, such as exports, are
+	// never signed and so are always rejected.
+	VerifyResponses bool
 }
 
 // NewClient creates a new TB client from a base url, hawk ID, and hawk secret
@@ -40,6 +81,12 @@ func NewClient(url string, hawkID string, hawkSecret string) (*Client, error) {
 }
 
 func (client Client) AuthRequest(req *http.Request, body []byte) {
+	client.authRequest(req, body)
+}
+
+// authRequest sets the Hawk Authorization header for req, returning the authorization to
+// verify the response with
+func (client Client) authRequest(req *http.Request, body []byte) *hawk.Auth {
 	req.Header.Set("Content-Type", "application/json")
 	auth := hawk.NewRequestAuth(req, client.Credentials, 0)
 	hash := auth.PayloadHash("application/json")
@@ -47,9 +94,52 @@ func (client Client) AuthRequest(req *http.Request, body []byte) {
 	hash.Write(body)
 	auth.SetHash(hash)
 	req.Header.Set("Authorization", auth.RequestHeader())
+	return auth
 }
 
-// SetReputation sets the reputation for an IPv4 CIDR to a specific value. If rev is set to
+// do authenticates and sends req, verifying the response signature if VerifyResponses is set
+func (client Client) do(req *http.Request, body []byte) (*http.Response, error) {
+	auth := client.authRequest(req, body)
+	resp, err := client.Do(req)
+	if err != nil || !client.VerifyResponses {
+		return resp, err
+	}
+	err = verifyResponse(auth, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyResponse checks the Server-Authorization header of resp against the authorization of
+// the request and the response payload. The body is read and replaced so it can still be read.
+func verifyResponse(auth *hawk.Auth, resp *http.Response) error {
+	// The response must carry its own payload hash rather than reuse the request's
+	auth.Hash = nil
+	err := auth.ValidResponse(resp.Header.Get("Server-Authorization"))
+	if err != nil {
+		return err
+	}
+	if auth.Hash == nil {
+		return ClientMissingPayloadHashError
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	hash := auth.PayloadHash(mediaType)
+	hash.Write(buf)
+	if !auth.ValidHash(hash) {
+		return ClientInvalidPayloadHashError
+	}
+	return nil
+}
+
+// SetReputation sets the reputation for an IPv4 or IPv6 CIDR to a specific value. If rev is set to
 // true, the reputation entry also has it's reviewed flag set to true in the database.
 func (client Client) SetReputation(cidr string, reputation uint, rev bool) (*http.Response, error) {
 	entry := ReputationEntry{
@@ -66,15 +156,15 @@ func (client Client) SetReputation(cidr string, reputation uint, rev bool) (*htt
 	if err != nil {
 		return nil, err
 	}
-	client.AuthRequest(req, body)
-	resp, err := client.Do(req)
+	resp, err := client.do(req, body)
 	if err != nil {
 		return resp, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		return resp, ClientUnexpectedPUTStatusError
 	}
-	return resp, nil
+	// The reputation of an excepted address is not set, and the exception is in the body
+	return resp, exceptedError(resp, nil)
 }
 
 // SetReviewed sets the review flag for a given CIDR to status
@@ -103,8 +193,7 @@ func (client Client) SetReviewed(cidr string, status bool) (*http.Response, erro
 	if err != nil {
 		return nil, err
 	}
-	client.AuthRequest(req, buf)
-	resp, err = client.Do(req)
+	resp, err = client.do(req, buf)
 	if err != nil {
 		return resp, err
 	}
@@ -114,13 +203,13 @@ func (client Client) SetReviewed(cidr string, status bool) (*http.Response, erro
 	return resp, nil
 }
 
-// BanIP sets the reputation for an IPv4 CIDR to 0 to block it for the maximum decay period
+// BanIP sets the reputation for an IPv4 or IPv6 CIDR to 0 to block it for the maximum decay period
 func (client Client) BanIP(cidr string) (*http.Response, error) {
 	// Since this is being applied from the ban command, set reviewed to true
 	return client.SetReputation(cidr, 0, true)
 }
 
-// UnbanIP sets the reputation for an IPv4 CIDR to 100 to immediately unblock it
+// UnbanIP sets the reputation for an IPv4 or IPv6 CIDR to 100 to immediately unblock it
 func (client Client) UnbanIP(cidr string) (*http.Response, error) {
 	return client.SetReputation(cidr, 100, false)
 }
@@ -132,8 +221,25 @@ func (client Client) Exceptions() (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	client.AuthRequest(req, []byte{})
-	resp, err := client.Do(req)
+	resp, err := client.do(req, []byte{})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, ClientUnexpectedGETStatusError
+	}
+	return resp, nil
+}
+
+// Reputations requests a page of reputation entries, filtered by the GET /reputations query
+// parameters in params
+func (client Client) Reputations(params url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET",
+		strings.TrimRight(client.URL, "/")+"/reputations?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(req, []byte{})
 	if err != nil {
 		return nil, err
 	}
@@ -143,15 +249,63 @@ func (client Client) Exceptions() (*http.Response, error) {
 	return resp, nil
 }
 
+// AddException adds an exception for an IPv4 or IPv6 CIDR with the given reason. If expires is
+// not the zero time the exception is removed at that time.
+func (client Client) AddException(cidr string, reason string, expires time.Time) (*http.Response, error) {
+	entry := ExceptionEntry{
+		IP:      cidr,
+		Reason:  reason,
+		Expires: expires,
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST",
+		strings.TrimRight(client.URL, "/")+"/exceptions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(req, body)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return resp, ClientUnexpectedPOSTStatusError
+	}
+	return resp, nil
+}
+
+// RemoveException removes the exceptions for an IPv4 or IPv6 CIDR that were added with
+// AddException
+func (client Client) RemoveException(cidr string) (*http.Response, error) {
+	req, err := http.NewRequest("DELETE",
+		strings.TrimRight(client.URL, "/")+"/exceptions/"+cidr, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(req, []byte{})
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, ClientUnexpectedDELETEStatusError
+	}
+	return resp, nil
+}
+
 // Reputation requests the reputation score for an IP address
 func (client Client) Reputation(ipaddr string) (*http.Response, error) {
 	req, err := http.NewRequest("GET",
 		strings.TrimRight(client.URL, "/")+"/"+ipaddr, nil)
-	client.AuthRequest(req, []byte{})
-	resp, err := client.Do(req)
+	resp, err := client.do(req, []byte{})
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp, exceptedError(resp, ClientUnexpectedGETStatusError)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return resp, ClientUnexpectedGETStatusError
 	}
@@ -15,7 +15,7 @@ import (
 var banCmd = &cobra.Command{
 	Use:   "ban",
 	Short: "Ban an IP for the maximum decay period (environment dependent).",
-	Long:  `Sets the reputation for an IPv4 CIDR to 0.`,
+	Long:  `Sets the reputation for an IPv4 or IPv6 CIDR to 0.`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("requires at least one CIDR")
@@ -37,6 +37,7 @@ var banCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
 			os.Exit(1)
 		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
 
 		_, err = client.BanIP(cidr)
 		if err != nil {
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -28,6 +30,7 @@ var exceptionsCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
 			os.Exit(1)
 		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
 
 		resp, err := client.Exceptions()
 		if err != nil {
@@ -47,11 +50,106 @@ var exceptionsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 		for _, x := range e {
-			fmt.Printf("%v %v %v %v\n", x.IP, x.Creator, x.Modified, x.Expires)
+			fmt.Printf("%v %v %v %v %q\n", x.IP, x.Creator, x.Modified, x.Expires, x.Reason)
 		}
 	},
 }
 
+var (
+	exceptionReason  string
+	exceptionExpires time.Duration
+)
+
+// exceptionsAddCmd represents the exceptions add command
+var exceptionsAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add an exception for an IPv4 or IPv6 CIDR.",
+	Long: `Add an exception for an IPv4 or IPv6 CIDR. The reputation of excepted addresses is not
+tracked. The exception is removed after the --expires duration, if one is given.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires at least one CIDR")
+		}
+		if !tigerblood.IsValidReputationCIDROrIP(args[0]) {
+			return fmt.Errorf("invalid CIDR specified: %s", args[0])
+		}
+		if exceptionReason == "" {
+			return errors.New("requires a --reason")
+		}
+		if exceptionExpires < 0 {
+			return fmt.Errorf("invalid expiry: %s", exceptionExpires)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cidr := args[0]
+		url := viper.GetString("URL")
+
+		client, err := tigerblood.NewClient(
+			url,
+			viper.GetString("HAWK_ID"),
+			viper.GetString("HAWK_SECRET"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
+			os.Exit(1)
+		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
+
+		var expires time.Time
+		if exceptionExpires > 0 {
+			expires = time.Now().Add(exceptionExpires)
+		}
+		_, err = client.AddException(cidr, exceptionReason, expires)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding exception: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added exception for %s on %s\n", cidr, url)
+	},
+}
+
+// exceptionsRmCmd represents the exceptions rm command
+var exceptionsRmCmd = &cobra.Command{
+	Use:   "rm",
+	Short: "Remove an exception for an IPv4 or IPv6 CIDR.",
+	Long:  `Remove an exception for an IPv4 or IPv6 CIDR that was added with the add command.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires at least one CIDR")
+		}
+		if tigerblood.IsValidReputationCIDROrIP(args[0]) {
+			return nil
+		}
+		return fmt.Errorf("invalid CIDR specified: %s", args[0])
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cidr := args[0]
+		url := viper.GetString("URL")
+
+		client, err := tigerblood.NewClient(
+			url,
+			viper.GetString("HAWK_ID"),
+			viper.GetString("HAWK_SECRET"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
+			os.Exit(1)
+		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
+
+		_, err = client.RemoveException(cidr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing exception: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed exception for %s on %s\n", cidr, url)
+	},
+}
+
 func init() {
+	exceptionsAddCmd.Flags().StringVar(&exceptionReason, "reason", "", "why the exception is needed")
+	exceptionsAddCmd.Flags().DurationVar(&exceptionExpires, "expires", 0,
+		"remove the exception after this duration, e.g. 24h (default never)")
+	exceptionsCmd.AddCommand(exceptionsAddCmd)
+	exceptionsCmd.AddCommand(exceptionsRmCmd)
 	rootCmd.AddCommand(exceptionsCmd)
 }
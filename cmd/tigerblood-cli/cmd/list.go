@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"go.mozilla.org/tigerblood"
+)
+
+var (
+	listMinReputation  int
+	listMaxReputation  int
+	listReviewed       string
+	listWithin         string
+	listModifiedSince  string
+	listModifiedBefore string
+	listLimit          int
+	listAll            bool
+	listJSON           bool
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List reputation entries.",
+	Long: `List reputation entries in address order, optionally filtered by reputation range, reviewed
+flag, containing CIDR and modification time. Only the first page of --limit entries is listed
+unless --all is given.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if listReviewed != "" {
+			if _, err := strconv.ParseBool(listReviewed); err != nil {
+				return fmt.Errorf("invalid reviewed flag specified: %s", listReviewed)
+			}
+		}
+		if listWithin != "" && !tigerblood.IsValidReputationCIDROrIP(listWithin) {
+			return fmt.Errorf("invalid CIDR specified: %s", listWithin)
+		}
+		for _, t := range []string{listModifiedSince, listModifiedBefore} {
+			if t == "" {
+				continue
+			}
+			if _, err := time.Parse(time.RFC3339, t); err != nil {
+				return fmt.Errorf("invalid time specified: %s", t)
+			}
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := tigerblood.NewClient(
+			viper.GetString("URL"),
+			viper.GetString("HAWK_ID"),
+			viper.GetString("HAWK_SECRET"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
+			os.Exit(1)
+		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
+
+		params := url.Values{}
+		params.Set("min_reputation", strconv.Itoa(listMinReputation))
+		params.Set("max_reputation", strconv.Itoa(listMaxReputation))
+		params.Set("limit", strconv.Itoa(listLimit))
+		for k, v := range map[string]string{
+			"reviewed":        listReviewed,
+			"within":          listWithin,
+			"modified_since":  listModifiedSince,
+			"modified_before": listModifiedBefore,
+		} {
+			if v != "" {
+				params.Set(k, v)
+			}
+		}
+
+		entries := []tigerblood.ReputationListEntry{}
+		for {
+			resp, err := client.Reputations(params)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error requesting reputation entries: %s\n", err)
+				os.Exit(1)
+			}
+			buf, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading response body: %s\n", err)
+				os.Exit(1)
+			}
+			var page struct {
+				Entries []tigerblood.ReputationListEntry
+				Next    string
+			}
+			err = json.Unmarshal(buf, &page)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error unmarshaling response: %s\n", err)
+				os.Exit(1)
+			}
+			entries = append(entries, page.Entries...)
+			if !listAll || page.Next == "" {
+				break
+			}
+			params.Set("after", page.Next)
+		}
+
+		if listJSON {
+			buf, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling entries: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(buf))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "IP\tREPUTATION\tREVIEWED\tMODIFIED")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%d\t%t\t%s\n", e.IP, e.Reputation, e.Reviewed,
+				e.Modified.Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	listCmd.Flags().IntVar(&listMinReputation, "min", 0, "minimum reputation")
+	listCmd.Flags().IntVar(&listMaxReputation, "max", 100, "maximum reputation")
+	listCmd.Flags().StringVar(&listReviewed, "reviewed", "", "only entries with this reviewed flag (true or false)")
+	listCmd.Flags().StringVar(&listWithin, "within", "", "only entries contained in this CIDR")
+	listCmd.Flags().StringVar(&listModifiedSince, "modified-since", "",
+		"only entries changed at or after this RFC 3339 time")
+	listCmd.Flags().StringVar(&listModifiedBefore, "modified-before", "",
+		"only entries changed before this RFC 3339 time")
+	listCmd.Flags().IntVar(&listLimit, "limit", 100, "number of entries per page")
+	listCmd.Flags().BoolVar(&listAll, "all", false, "list all pages of entries")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "output entries as JSON")
+	rootCmd.AddCommand(listCmd)
+}
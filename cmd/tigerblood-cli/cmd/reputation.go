@@ -40,9 +40,14 @@ var reputationCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
 			os.Exit(1)
 		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
 
 		resp, err := client.Reputation(ipaddr)
 		if err != nil {
+			if _, ok := err.(tigerblood.ClientExceptedError); ok {
+				fmt.Printf("reputation entry not found, %s\n", err)
+				os.Exit(0)
+			}
 			if resp != nil && resp.StatusCode == http.StatusNotFound {
 				fmt.Printf("reputation entry not found\n")
 				os.Exit(0)
@@ -45,6 +45,7 @@ var reviewedCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
 			os.Exit(1)
 		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
 
 		_, err = client.SetReviewed(ipaddr, flag)
 		if err != nil {
@@ -17,6 +17,7 @@ var rootCmd = &cobra.Command{
 	Short: "Command line client for managing IP Reputations",
 	Long: `Command line client for managing IP Reputations. It requires the environment variables
 TIGERBLOOD_HAWK_ID, TIGERBLOOD_HAWK_SECRET, TIGERBLOOD_URL to be set, or a valid config file.
+Set TIGERBLOOD_VERIFY_RESPONSES=true to reject responses without a valid Hawk signature.
 
 Example usage:
 
@@ -39,6 +40,7 @@ func init() {
 	viper.SetDefault("HAWK_ID", nil)
 	viper.SetDefault("HAWK_SECRET", nil)
 	viper.SetDefault("URL", "https://tigerblood.stage.mozaws.net/")
+	viper.SetDefault("VERIFY_RESPONSES", false)
 
 	viper.SetEnvPrefix("tigerblood")
 
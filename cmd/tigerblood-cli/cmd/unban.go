@@ -14,8 +14,8 @@ import (
 // unbanCmd represents the unban command
 var unbanCmd = &cobra.Command{
 	Use:   "unban",
-	Short: "Sets the reputation for an IPv4 CIDR to the maximum (100) to unban an IP.",
-	Long:  `Sets the reputation for an IPv4 CIDR to 100.`,
+	Short: "Sets the reputation for an IPv4 or IPv6 CIDR to the maximum (100) to unban an IP.",
+	Long:  `Sets the reputation for an IPv4 or IPv6 CIDR to 100.`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("requires at least one CIDR")
@@ -37,6 +37,7 @@ var unbanCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error creating tigerblood client: %s\n", err)
 			os.Exit(1)
 		}
+		client.VerifyResponses = viper.GetBool("VERIFY_RESPONSES")
 
 		_, err = client.UnbanIP(cidr)
 		if err != nil {
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.mozilla.org/tigerblood"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// loadCredentials loads the credentials of the authentication modes in authmask from the
+// configuration and CREDENTIALS_DIR, along with the scopes granted to them, and puts them in
+// use. Nothing is changed if an error is returned.
+func loadCredentials(authmask int) error {
+	var (
+		hawkCredentials map[string]string
+		apiKeys         map[string][]tigerblood.APIKey
+		mtlsData        *tigerblood.MTLSData
+		credentialIDs   []string
+		err             error
+	)
+	if authmask&tigerblood.AuthEnableHawk != 0 {
+		hawkCredentials, err = loadHawkCredentials()
+		if err != nil {
+			return err
+		}
+		for id := range hawkCredentials {
+			credentialIDs = append(credentialIDs, id)
+		}
+	}
+	if authmask&tigerblood.AuthEnableAPIKey != 0 {
+		apiKeys, err = loadAPIKeys()
+		if err != nil {
+			return err
+		}
+		for id := range apiKeys {
+			credentialIDs = append(credentialIDs, id)
+		}
+	}
+
+	if authmask&tigerblood.AuthEnableMTLS != 0 {
+		credentials := viper.GetStringMapString("MTLS_CREDENTIALS")
+		if len(credentials) == 0 {
+			return fmt.Errorf("mTLS authentication was enabled, but no credentials were found")
+		}
+		mtlsData, err = tigerblood.NewMTLSData(credentials)
+		if err != nil {
+			return err
+		}
+		for id := range credentials {
+			credentialIDs = append(credentialIDs, id)
+		}
+		log.Printf("mTLS authentication enabled with %d credentials.", len(credentials))
+	}
+
+	scopes := viper.GetStringMapString("CREDENTIAL_SCOPES")
+	for _, id := range credentialIDs {
+		if _, ok := scopes[id]; !ok {
+			return fmt.Errorf("No scopes configured for credential %s, add them to CREDENTIAL_SCOPES", id)
+		}
+	}
+	err = tigerblood.SetCredentialScopes(scopes)
+	if err != nil {
+		return err
+	}
+	if hawkCredentials != nil {
+		tigerblood.SetHawkCredentials(hawkCredentials)
+	}
+	if apiKeys != nil {
+		tigerblood.SetAPIKeys(apiKeys)
+	}
+	if mtlsData != nil {
+		tigerblood.SetMTLSData(mtlsData)
+	}
+	return nil
+}
+
+func loadHawkCredentials() (map[string]string, error) {
+	credentials := viper.GetStringMapString("HAWK_CREDENTIALS")
+	if dir := viper.GetString("CREDENTIALS_DIR"); dir != "" {
+		files, err := readCredentialFiles(filepath.Join(dir, "hawk"))
+		if err != nil {
+			return nil, err
+		}
+		for id, secret := range files {
+			credentials[id] = strings.TrimSpace(secret)
+		}
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("Hawk was enabled, but no credentials were found")
+	}
+	log.Printf("Hawk enabled with %d credentials.", len(credentials))
+	return credentials, nil
+}
+
+func loadAPIKeys() (map[string][]tigerblood.APIKey, error) {
+	keys, err := tigerblood.ParseAPIKeyCredentials(viper.GetStringMap("APIKEY_CREDENTIALS"))
+	if err != nil {
+		return nil, err
+	}
+	if dir := viper.GetString("CREDENTIALS_DIR"); dir != "" {
+		files, err := readCredentialFiles(filepath.Join(dir, "apikey"))
+		if err != nil {
+			return nil, err
+		}
+		for id, specs := range files {
+			for _, spec := range strings.Split(specs, "\n") {
+				spec = strings.TrimSpace(spec)
+				if spec == "" || strings.HasPrefix(spec, "#") {
+					continue
+				}
+				key, err := tigerblood.ParseAPIKey(spec)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid API key for %s: %s", id, err)
+				}
+				keys[id] = append(keys[id], key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("API key authentication was enabled, but no credentials were found")
+	}
+	log.Printf("API key authentication enabled with %d credentials.", len(keys))
+	return keys, nil
+}
+
+// readCredentialFiles returns the contents of the files in dir by file name, skipping hidden
+// files. A missing dir has no credentials.
+func readCredentialFiles(dir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		// Stat follows symlinks, as used by mounted Kubernetes secrets
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files[e.Name()] = string(buf)
+	}
+	return files, nil
+}
+
+// reloadOnHangup rereads the config file and reloads credentials, and the TLS certificate if
+// tlsConfig is not nil, each time the process receives SIGHUP. The current credentials and
+// certificate are kept if reloading them fails.
+func reloadOnHangup(authmask int, tlsConfig *tigerblood.ReloadingTLSConfig) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Print("Reloading credentials")
+			err := viper.ReadInConfig()
+			if err == nil {
+				err = loadCredentials(authmask)
+			}
+			if err != nil {
+				log.Errorf("Error reloading credentials, keeping the current credentials: %s", err)
+			}
+			if tlsConfig != nil {
+				log.Print("Reloading TLS certificate")
+				err = tlsConfig.Reload()
+				if err != nil {
+					log.Errorf("Error reloading TLS certificate, keeping the current certificate: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// runHashAPIKey reads an API key from stdin and prints its salted hash for use in
+// APIKEY_CREDENTIALS or CREDENTIALS_DIR
+func runHashAPIKey() {
+	secret, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		log.Fatalf("Usage: tigerblood hash-apikey < keyfile (%v)", err)
+	}
+	key, err := tigerblood.HashAPIKey(secret)
+	if err != nil {
+		log.Fatalf("Error hashing API key: %s", err)
+	}
+	fmt.Println(key)
+}
@@ -11,9 +11,11 @@ import (
 	"go.mozilla.org/tigerblood"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 func printConfig() {
@@ -57,6 +59,8 @@ func loadConfig() {
 	viper.SetDefault("BIND_ADDR", "127.0.0.1:8080")
 	viper.SetDefault("STATSD_ADDR", "127.0.0.1:8125")
 	viper.SetDefault("STATSD_NAMESPACE", "tigerblood.")
+	viper.SetDefault("STATSD_GAUGE_INTERVAL", "1m")
+	viper.SetDefault("METRICS_EXCEPTION_INTERVAL", "1m")
 	viper.SetDefault("HAWK", false)
 	viper.SetDefault("APIKEY", false)
 	viper.SetDefault("PUBLISH_RUNTIME_STATS", false)
@@ -66,6 +70,15 @@ func loadConfig() {
 	viper.SetDefault("RUNTIME_GC", true)
 	viper.SetDefault("PROFILE", false)
 	viper.SetDefault("MAX_ENTRIES", 1000)
+	viper.SetDefault("DECAY", false)
+	viper.SetDefault("DECAY_RATE", 1)
+	viper.SetDefault("DECAY_INTERVAL", "1h")
+	viper.SetDefault("DECAY_DELETE_RECOVERED", false)
+	viper.SetDefault("VIOLATION_HISTORY_RETENTION", "720h")
+	viper.SetDefault("VIOLATION_REFRESH_INTERVAL", "30s")
+	viper.SetDefault("HAWK_SIGN_RESPONSES", false)
+	viper.SetDefault("HAWK_NONCE_STORE", "memory")
+	viper.SetDefault("HAWK_NONCE_CACHE_SIZE", tigerblood.DefaultNonceCacheSize)
 
 	viper.SetEnvPrefix("tigerblood")
 	viper.AutomaticEnv()
@@ -75,24 +88,38 @@ func loadConfig() {
 	}
 }
 
-func loadHawkCredentials() map[string]string {
-	credentials := viper.GetStringMapString("HAWK_CREDENTIALS")
-	if len(credentials) == 0 {
-		log.Fatal("Hawk was enabled, but no credentials were found.")
-	} else {
-		log.Printf("Hawk enabled with %d credentials.", len(credentials))
+func loadTLSConfig() *tigerblood.ReloadingTLSConfig {
+	certFile := viper.GetString("TLS_CERT_FILE")
+	keyFile := viper.GetString("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must both be set to enable TLS.")
+	}
+	tlsConfig, err := tigerblood.NewReloadingTLSConfig(certFile, keyFile, viper.GetString("TLS_CLIENT_CA_FILE"))
+	if err != nil {
+		log.Fatal(err)
 	}
-	return credentials
+	return tlsConfig
 }
 
-func loadAPIKeyCredentials() map[string]string {
-	credentials := viper.GetStringMapString("APIKEY_CREDENTIALS")
-	if len(credentials) == 0 {
-		log.Fatal("API key authentication was enabled, but no credentials were found.")
-	} else {
-		log.Printf("API key authentication enabled with %d credentials.", len(credentials))
+func loadHawkNonceStore(db *tigerblood.DB) {
+	switch store := viper.GetString("HAWK_NONCE_STORE"); store {
+	case "memory":
+		size := viper.GetInt("HAWK_NONCE_CACHE_SIZE")
+		if size <= 0 {
+			log.Fatalf("Invalid hawk nonce cache size: %d", size)
+		}
+		tigerblood.SetHawkNonceStore(tigerblood.NewLRUNonceStore(size))
+		log.Printf("Hawk nonces are kept in memory (%d nonces)", size)
+	case "postgres":
+		tigerblood.SetHawkNonceStore(tigerblood.NewDBNonceStore(db))
+		tigerblood.StartHawkNoncePurge()
+		log.Print("Hawk nonces are kept in the database")
+	default:
+		log.Fatalf("Invalid hawk nonce store: %s", store)
 	}
-	return credentials
 }
 
 func loadDB() *tigerblood.DB {
@@ -133,7 +160,7 @@ func loadStatsd() *statsd.Client {
 
 func loadViolationPenalties() map[string]uint {
 	if !viper.IsSet("VIOLATION_PENALTIES") {
-		log.Fatal("No violation penalties found.")
+		return nil
 	}
 
 	// pass as violation_type=penalty (e.g. rateLimited=20) to
@@ -170,19 +197,89 @@ func loadViolationPenalties() map[string]uint {
 		}
 		vms += fmt.Sprintf("%s=%d", x, penalties[x])
 	}
-	log.Printf("loaded violation map: %s", vms)
+	log.Printf("loaded violation seed map: %s", vms)
 
 	return penalties
 }
 
+// loadViolations seeds the violation catalog from VIOLATION_PENALTIES, loads it, and starts
+// refreshing it every VIOLATION_REFRESH_INTERVAL
+func loadViolations() {
+	err := tigerblood.SeedViolationCatalog(loadViolationPenalties())
+	if err != nil {
+		log.Fatalf("Error seeding violation catalog: %s", err)
+	}
+	err = tigerblood.LoadViolationTypes()
+	if err != nil {
+		log.Fatalf("Error loading violation catalog: %s", err)
+	}
+	interval, err := time.ParseDuration(viper.GetString("VIOLATION_REFRESH_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing violation refresh interval: %s", err)
+	}
+	tigerblood.StartViolationTypeRefresh(interval)
+}
+
+func loadViolationRecovery() map[string]time.Duration {
+	var recovery = make(map[string]time.Duration)
+	if !viper.IsSet("VIOLATION_RECOVERY") {
+		return recovery
+	}
+
+	// pass as violation_type=duration (e.g. rateLimited=1h), see loadViolationPenalties
+	for _, kv := range strings.Split(viper.GetString("VIOLATION_RECOVERY"), ",") {
+		tmp := strings.Split(kv, "=")
+		if len(tmp) != 2 {
+			log.Fatalf("Error loading violation recovery %s (format should be type=duration)", tmp)
+		}
+		violationType, period := tmp[0], tmp[1]
+		if !tigerblood.IsValidViolationName(violationType) {
+			log.Fatalf("Invalid violation type: %s", violationType)
+		}
+		parsedPeriod, err := time.ParseDuration(period)
+		if err != nil {
+			log.Fatalf("Error parsing violation recovery %s: %s", period, err)
+		}
+		if parsedPeriod <= 0 {
+			log.Fatalf("Invalid violation recovery: %s: %s", violationType, parsedPeriod)
+		}
+		recovery[violationType] = parsedPeriod
+	}
+	var vms string
+	for x := range recovery {
+		if vms != "" {
+			vms += ", "
+		}
+		vms += fmt.Sprintf("%s=%s", x, recovery[x])
+	}
+	log.Printf("loaded violation recovery map: %s", vms)
+
+	return recovery
+}
+
+// exceptionSources returns the exception source configurations in EXCEPTIONS, which is either
+// a list, or a string of sources separated by whitespace, which cannot appear in a URL. A string
+// without whitespace is separated by commas, the original format.
+func exceptionSources() []string {
+	switch viper.Get("EXCEPTIONS").(type) {
+	case []interface{}, []string:
+		return viper.GetStringSlice("EXCEPTIONS")
+	}
+	s := viper.GetString("EXCEPTIONS")
+	if strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return strings.Fields(s)
+	}
+	return strings.Split(s, ",")
+}
+
 func loadExceptions() {
 	if !viper.IsSet("EXCEPTIONS") {
 		return
 	}
 
-	for _, kv := range strings.Split(viper.GetString("EXCEPTIONS"), ",") {
+	for _, kv := range exceptionSources() {
 		var ed, ec string
-		tmp := strings.Split(kv, "=")
+		tmp := strings.SplitN(kv, "=", 2)
 		if len(tmp) == 0 {
 			continue
 		}
@@ -200,21 +297,90 @@ func loadExceptions() {
 				log.Fatalf("Error adding file exception: %s", err)
 			}
 		case "aws":
-			// No configuration
-			log.Print("Adding exception source AWS public address data")
-			err := tigerblood.AddAWSException()
+			// Configuration is an optional list of region and service filters
+			log.Printf("Adding exception source AWS public address data %s", ec)
+			err := tigerblood.AddAWSException(ec)
 			if err != nil {
 				log.Fatalf("Error adding AWS exception: %s", err)
 			}
+		case "url":
+			// Configuration is the URL followed by optional JSON path, polling
+			// interval and size limit options
+			log.Printf("Adding exception source URL %s", ec)
+			err := tigerblood.AddURLException(ec)
+			if err != nil {
+				log.Fatalf("Error adding URL exception: %s", err)
+			}
+		case "tor":
+			// Configuration is an optional list file or URL, and a penalty to
+			// apply to exit addresses instead of excepting them
+			log.Printf("Adding exception source Tor exit list %s", ec)
+			err := tigerblood.AddTorException(ec)
+			if err != nil {
+				log.Fatalf("Error adding Tor exception: %s", err)
+			}
 		default:
 			log.Fatalf("Invalid exception source type %s", ed)
 		}
 	}
 }
 
+func loadTableGauges() {
+	interval, err := time.ParseDuration(viper.GetString("STATSD_GAUGE_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing statsd gauge interval: %s", err)
+	}
+	tigerblood.StartTableGauges(interval)
+}
+
+func loadExceptionCounts() {
+	interval, err := time.ParseDuration(viper.GetString("METRICS_EXCEPTION_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing metrics exception interval: %s", err)
+	}
+	tigerblood.StartExceptionCounts(interval)
+}
+
+func loadDecay() {
+	if !viper.GetBool("DECAY") {
+		log.Print("Reputation decay disabled")
+		return
+	}
+	interval, err := time.ParseDuration(viper.GetString("DECAY_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing decay interval: %s", err)
+	}
+	rate := viper.GetInt("DECAY_RATE")
+	if rate < 0 || rate > 100 {
+		log.Fatalf("Invalid decay rate %d (must be 0 to 100)", rate)
+	}
+	tigerblood.StartDecay(uint(rate), interval, viper.GetBool("DECAY_DELETE_RECOVERED"))
+}
+
+func loadViolationHistoryRetention() {
+	if viper.GetString("VIOLATION_HISTORY_RETENTION") == "0" {
+		log.Print("Violation history will be kept indefinitely")
+		return
+	}
+	retention, err := time.ParseDuration(viper.GetString("VIOLATION_HISTORY_RETENTION"))
+	if err != nil {
+		log.Fatalf("Error parsing violation history retention: %s", err)
+	}
+	tigerblood.StartViolationHistoryPurge(retention)
+}
+
 func main() {
 	mozlogrus.Enable("tigerblood")
+	if len(os.Args) > 1 && os.Args[1] == "hash-apikey" {
+		runHashAPIKey()
+		return
+	}
 	loadConfig()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
 	printConfig()
 
 	var (
@@ -222,44 +388,80 @@ func main() {
 		authmask   int
 	)
 
+	err := tigerblood.SetTrustedProxies(strings.Split(viper.GetString("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	middleware = append(middleware, tigerblood.RequestSummary())
+
 	if viper.GetBool("HAWK") {
-		tigerblood.SetHawkCredentials(loadHawkCredentials())
+		tigerblood.SetHawkSignResponses(viper.GetBool("HAWK_SIGN_RESPONSES"))
 		authmask |= tigerblood.AuthEnableHawk
 	}
 	if viper.GetBool("APIKEY") {
-		tigerblood.SetAPIKeyCredentials(loadAPIKeyCredentials())
 		authmask |= tigerblood.AuthEnableAPIKey
 	}
+	tlsConfig := loadTLSConfig()
+	if viper.GetBool("MTLS") {
+		if tlsConfig == nil || viper.GetString("TLS_CLIENT_CA_FILE") == "" {
+			log.Fatal("mTLS authentication was enabled, but TLS_CERT_FILE, TLS_KEY_FILE and TLS_CLIENT_CA_FILE are not all set.")
+		}
+		authmask |= tigerblood.AuthEnableMTLS
+	}
+	err = loadCredentials(authmask)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if authmask != 0 || tlsConfig != nil {
+		reloadOnHangup(authmask, tlsConfig)
+	}
 	middleware = append(middleware, tigerblood.RequireAuth())
 	tigerblood.SetAuthMask(authmask)
 
 	tigerblood.SetProfileHandlers(viper.GetBool("PROFILE"))
 
-	tigerblood.SetDB(loadDB())
+	db := loadDB()
+	tigerblood.SetDB(db)
+	if authmask&tigerblood.AuthEnableHawk != 0 {
+		loadHawkNonceStore(db)
+	}
 
 	loadExceptions()
-	err := tigerblood.InitializeExceptions()
+	err = tigerblood.InitializeExceptions()
 	if err != nil {
 		log.Fatalf("Error initializing exception sources: %s", err)
 	}
 
 	if viper.IsSet("STATSD_ADDR") {
 		tigerblood.SetStatsdClient(loadStatsd())
+		loadTableGauges()
 	} else {
 		log.Println("statsd not found")
 	}
 
-	tigerblood.SetViolationPenalties(loadViolationPenalties())
+	loadViolations()
+	tigerblood.SetViolationRecovery(loadViolationRecovery())
 	tigerblood.SetMaxEntries(viper.GetInt("MAX_ENTRIES"))
+	loadExceptionCounts()
+	loadDecay()
+	loadViolationHistoryRetention()
 
 	middleware = append(middleware, tigerblood.SetResponseHeaders())
 
 	if authmask == 0 {
 		log.Warn("Warning, authentication is disabled")
 	}
-	log.Printf("Listening on %s", viper.GetString("BIND_ADDR"))
-	err = http.ListenAndServe(
-		viper.GetString("BIND_ADDR"),
-		tigerblood.HandleWithMiddleware(tigerblood.NewRouter(), middleware))
+	server := &http.Server{
+		Addr:    viper.GetString("BIND_ADDR"),
+		Handler: tigerblood.HandleWithMiddleware(tigerblood.NewRouter(), middleware),
+	}
+	if tlsConfig != nil {
+		log.Printf("Listening on %s with TLS", server.Addr)
+		server.TLSConfig = tlsConfig.TLSConfig()
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Listening on %s", server.Addr)
+		err = server.ListenAndServe()
+	}
 	log.Fatal(err)
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.mozilla.org/tigerblood"
+	"os"
+	"text/tabwriter"
+)
+
+const migrateUsage = "usage: tigerblood migrate up|down|status"
+
+// runMigrate implements the migrate subcommand, which manages the database schema
+// version without starting the server
+func runMigrate(args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+	if !viper.IsSet("DSN") {
+		log.Fatalf("No DSN found. Cannot continue without a database")
+	}
+	db, err := tigerblood.OpenDB(viper.GetString("DSN"))
+	if err != nil {
+		log.Fatalf("Could not connect to database: %s", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		err = db.MigrateUp()
+	case "down":
+		err = db.MigrateDown()
+	case "status":
+		err = printMigrationStatus(db)
+	default:
+		log.Fatal(migrateUsage)
+	}
+	if err != nil {
+		log.Fatalf("Error running migrate %s: %s", args[0], err)
+	}
+	if args[0] != "status" {
+		version, err := db.SchemaVersion()
+		if err != nil {
+			log.Fatalf("Could not get schema version: %s", err)
+		}
+		log.Printf("Database schema is at version %d", version)
+	}
+}
+
+func printMigrationStatus(db *tigerblood.DB) error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database schema version %d, latest version %d\n\n", version,
+		tigerblood.LatestSchemaVersion())
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+	for _, s := range status {
+		applied := "pending"
+		if !s.Applied.IsZero() {
+			applied = s.Applied.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, s.Description)
+	}
+	return w.Flush()
+}
@@ -50,6 +50,44 @@ type ReputationEntry struct {
 	Reviewed   bool   // True if the entry has the reviewed flag set
 }
 
+// Reputation lookup statuses
+const (
+	LookupFound    = "found"    // A reputation entry contains the address
+	LookupUnknown  = "unknown"  // No reputation entry contains the address
+	LookupExcepted = "excepted" // An exception covers the address, so its reputation is not reported
+)
+
+// ReputationLookup is the result of looking up the reputation of an IP address or network
+type ReputationLookup struct {
+	IP         string // The IP address or network looked up
+	Status     string // One of LookupFound, LookupUnknown or LookupExcepted
+	Subnet     string // The smallest subnet with a reputation entry containing IP, if found
+	Reputation uint   // The reputation of Subnet if found, otherwise 100
+	Reviewed   bool   // The reviewed flag of Subnet if found
+	// The most specific exception covering IP if excepted, otherwise nil
+	Exception *ExceptionEntry
+}
+
+// ReputationPenalty is a violation penalty to apply to an IP
+type ReputationPenalty struct {
+	IP         string        // The IP address the penalty applies to
+	Violation  string        // The violation type name
+	Penalty    uint          // The reputation penalty
+	Recovery   time.Duration // Time over which the penalty is restored, or 0 to use the default decay rate
+	Credential string        // ID of the credential that reported the violation, if any
+}
+
+// ViolationEvent is a record of a violation penalty applied to an IP
+type ViolationEvent struct {
+	ID         int64     // Event ID, used as the pagination cursor
+	IP         string    // The IP address the violation was applied to
+	Violation  string    // The violation type name
+	Penalty    uint      // The penalty for the violation type
+	Reputation uint      // The reputation after the penalty was applied
+	Credential string    // ID of the credential that reported the violation, if any
+	Created    time.Time // When the violation was applied
+}
+
 // IPViolationEntry an (IP, Violation) where Violation is the violation type name
 type IPViolationEntry struct {
 	IP        string
@@ -62,6 +100,7 @@ type ExceptionEntry struct {
 	Creator  string    // Entity that created exception
 	Modified time.Time // Entry modification date
 	Expires  time.Time // Entry expiry date
+	Reason   string    // Why the exception was added, if known
 }
 
 func checkConnection(db *DB) {
@@ -84,8 +123,9 @@ func checkConnection(db *DB) {
 	}
 }
 
-// NewDB creates a new DB instance from a DSN.
-func NewDB(dsn string) (*DB, error) {
+// OpenDB creates a new DB instance from a DSN without applying schema migrations or
+// preparing statements. It is intended for managing migrations; use NewDB to serve requests.
+func OpenDB(dsn string) (*DB, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
@@ -98,10 +138,28 @@ func NewDB(dsn string) (*DB, error) {
 	db.SetMaxIdleConns(75)   // default is 2: https://golang.org/src/database/sql/sql.go#L652
 	db.SetConnMaxLifetime(0) // don't timeout
 
-	newDB := &DB{
+	return &DB{
 		DB:          db,
 		closeNotify: make(chan bool, 1),
 		wait:        &sync.WaitGroup{},
+	}, nil
+}
+
+// NewDB creates a new DB instance from a DSN, applying any pending schema migrations. It
+// returns an error if the database schema is newer than this version of tigerblood supports.
+func NewDB(dsn string) (*DB, error) {
+	newDB, err := OpenDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := newDB.DB
+	version, err := newDB.SchemaVersion()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get schema version: %s", err)
+	}
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("Database schema version %d is newer than supported version %d",
+			version, LatestSchemaVersion())
 	}
 	err = newDB.CreateTables()
 	if err != nil {
@@ -122,77 +180,39 @@ func NewDB(dsn string) (*DB, error) {
 	return newDB, nil
 }
 
-// Creates the reputation table (or modifies it to match the schema we want). Done in a few
-// steps here to support migration of the schema from older to newer versions.
-const createReputationTableSQL = `
-CREATE TABLE IF NOT EXISTS reputation (
-ip ip4r PRIMARY KEY NOT NULL,
-reputation int NOT NULL CHECK (reputation >= 0 AND reputation <= 100)
-);
-CREATE INDEX IF NOT EXISTS reputation_ip_idx ON reputation USING gist (ip);
-
-DO $$
-	BEGIN
-		ALTER TABLE reputation ADD COLUMN reviewed boolean DEFAULT false;
-	EXCEPTION
-		WHEN duplicate_column THEN -- ignore error
-	END;
-$$;
-
-CREATE OR REPLACE FUNCTION reviewed_reset() RETURNS TRIGGER AS $$
-	BEGIN
-		NEW.reviewed = false;
-		RETURN NEW;
-	END;
-$$ LANGUAGE plpgsql;
-
-DROP TRIGGER IF EXISTS check_reviewed ON reputation;
-CREATE TRIGGER check_reviewed BEFORE UPDATE ON reputation
-	FOR EACH ROW WHEN (NEW.reputation = 100)
-	EXECUTE PROCEDURE reviewed_reset();
+const emptyReputationTableSQL = `
+TRUNCATE TABLE reputation, reputation_penalty, violation_event, audit;
 `
 
-const createExceptionTableSQL = `
-CREATE TABLE IF NOT EXISTS exception (
-ip ip4r NOT NULL,
-modified timestamp with time zone NOT NULL,
-expires timestamp with time zone,
-creator text NOT NULL,
-UNIQUE(ip, creator)
-);
-CREATE INDEX IF NOT EXISTS exception_ip_idx ON exception USING gist (ip);
+const emptyExceptionTableSQL = `
+TRUNCATE TABLE exception;
 `
 
-const emptyReputationTableSQL = `
-TRUNCATE TABLE reputation;
+const emptyViolationTableSQL = `
+TRUNCATE TABLE violation;
 `
 
-const emptyExceptionTableSQL = `
-TRUNCATE TABLE exception;
+const emptyHawkNonceTableSQL = `
+TRUNCATE TABLE hawk_nonce;
 `
 
 // Close closes the database
 func (db DB) Close() error {
 	db.closeNotify <- true
 	db.wait.Wait()
-	err := db.reputationSelectStmt.Close()
-	if err != nil {
-		return err
+	if db.reputationSelectStmt != nil {
+		err := db.reputationSelectStmt.Close()
+		if err != nil {
+			return err
+		}
 	}
 	return db.DB.Close()
 }
 
-// CreateTables creates all the tables tigerblood needs, if they don't exist already
+// CreateTables creates all the tables tigerblood needs by applying any pending schema
+// migrations
 func (db DB) CreateTables() error {
-	err := db.createReputationTable()
-	if err != nil {
-		return fmt.Errorf("Could not create reputation table: %s", err)
-	}
-	err = db.createExceptionTable()
-	if err != nil {
-		return fmt.Errorf("Could not create exception table: %s", err)
-	}
-	return nil
+	return db.MigrateUp()
 }
 
 // EmptyTables truncates the tigerblood tables
@@ -205,24 +225,22 @@ func (db DB) EmptyTables() error {
 	if err != nil {
 		return fmt.Errorf("Could not truncate exception table: %s", err)
 	}
+	_, err = db.Exec(emptyViolationTableSQL)
+	if err != nil {
+		return fmt.Errorf("Could not truncate violation table: %s", err)
+	}
+	_, err = db.Exec(emptyHawkNonceTableSQL)
+	if err != nil {
+		return fmt.Errorf("Could not truncate hawk_nonce table: %s", err)
+	}
 	return nil
 }
 
-func (db DB) createReputationTable() error {
-	_, err := db.Exec(createReputationTableSQL)
-	return err
-}
-
 func (db DB) emptyReputationTable() error {
 	_, err := db.Exec(emptyReputationTableSQL)
 	return err
 }
 
-func (db DB) createExceptionTable() error {
-	_, err := db.Exec(createExceptionTableSQL)
-	return err
-}
-
 func (db DB) emptyExceptionTable() error {
 	_, err := db.Exec(emptyExceptionTableSQL)
 	return err
@@ -231,13 +249,17 @@ func (db DB) emptyExceptionTable() error {
 // InsertOrUpdateReputationEntry inserts a single ReputationEntry into the database, or if it already
 // exists it updates it
 func (db DB) InsertOrUpdateReputationEntry(tx *sql.Tx, entry ReputationEntry) (ret uint, err error) {
+	defer dbTiming("insert_or_update_reputation_entry", time.Now())
 	query := db.QueryRow
 	if tx != nil {
 		query = tx.QueryRow
 	}
-	err = query("INSERT INTO reputation (ip, reputation, reviewed) "+
+	// Setting a reputation explicitly discards any recorded violation penalties, so
+	// the entry recovers at the default decay rate from the new value
+	err = query("WITH cleared AS (DELETE FROM reputation_penalty WHERE ip = $1) "+
+		"INSERT INTO reputation (ip, reputation, reviewed) "+
 		"SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM exception WHERE $1 <<= ip) "+
-		"ON CONFLICT (ip) DO UPDATE SET reputation = $2, reviewed = $3 "+
+		"ON CONFLICT (ip) DO UPDATE SET reputation = $2, reviewed = $3, modified = now() "+
 		"RETURNING reputation;", entry.IP,
 		entry.Reputation, entry.Reviewed).Scan(&ret)
 	if pqErr, ok := err.(*pq.Error); ok {
@@ -254,50 +276,229 @@ func (db DB) InsertOrUpdateReputationEntry(tx *sql.Tx, entry ReputationEntry) (r
 	return ret, nil
 }
 
-// InsertOrUpdateReputationPenalties applies a reputationPenalty to the
+// InsertOrUpdateReputationPenalties applies each ReputationPenalty to the
 // default reputation (100) and inserts a reputationEntry or updates
-// a reputationEntry with the penalty
-func (db DB) InsertOrUpdateReputationPenalties(tx *sql.Tx, ips []string,
-	reputationPenalties []uint) (ret []uint, err error) {
+// a reputationEntry with the penalty. The penalty is also recorded along
+// with the violation and its recovery period so the decay routine can
+// restore it over time, and a ViolationEvent is appended to the violation
+// history.
+//
+// The returned slice contains the resulting reputation for each penalty,
+// or 100 if the IP was excluded by an exception.
+func (db DB) InsertOrUpdateReputationPenalties(tx *sql.Tx,
+	reputationPenalties []ReputationPenalty) (ret []uint, err error) {
+	defer dbTiming("insert_or_update_reputation_penalties", time.Now())
 	query := db.QueryRow
 	if tx != nil {
 		query = tx.QueryRow
 	}
-	if len(ips) != len(reputationPenalties) {
-		return ret, fmt.Errorf("IP and penalty list mismatched length")
-	}
 
 	var vnew uint
-	for i := range ips {
-		vip, vpenalty := ips[i], reputationPenalties[i]
-		err := query("INSERT INTO reputation (ip, reputation) "+
+	for _, p := range reputationPenalties {
+		// Only the amount the reputation actually dropped is recorded, so recovery does
+		// not raise it above where it was before the violation
+		var recovery sql.NullFloat64
+		if p.Recovery > 0 {
+			recovery.Valid = true
+			recovery.Float64 = p.Recovery.Seconds()
+		}
+		err := query("WITH old AS (SELECT reputation FROM reputation WHERE ip = $1), "+
+			"rep AS (INSERT INTO reputation (ip, reputation) "+
 			"SELECT $1, 100 - $2 WHERE NOT EXISTS (SELECT 1 FROM exception WHERE $1 <<= ip) "+
 			"ON CONFLICT (ip) DO UPDATE SET "+
 			"reputation = GREATEST(0, LEAST(excluded.reputation, reputation.reputation - "+
-			"(100 - excluded.reputation))) RETURNING reputation",
-			vip, vpenalty).Scan(&vnew)
+			"(100 - excluded.reputation))), modified = now() RETURNING ip, reputation), "+
+			"pen AS (INSERT INTO reputation_penalty (ip, violation, penalty, recovery) "+
+			"SELECT ip, $3, LEAST($2, COALESCE((SELECT reputation FROM old), 100)), "+
+			"$4 * interval '1 second' FROM rep), "+
+			"ev AS (INSERT INTO violation_event (ip, violation, penalty, reputation, credential) "+
+			"SELECT ip, $3, $2, reputation, NULLIF($5, '') FROM rep) "+
+			"SELECT reputation FROM rep",
+			p.IP, p.Penalty, p.Violation, recovery, p.Credential).Scan(&vnew)
 		if err != nil {
 			if err != sql.ErrNoRows {
 				return ret, err
 			}
 			// Otherwise it was excluded by an exception, so just mark it as 100
 			ret = append(ret, 100)
+			continue
 		}
 		ret = append(ret, vnew)
 	}
 	return ret, nil
 }
 
+// SelectViolationEvents returns the violation history for IP, or for all addresses contained in
+// it if IP is a subnet, newest first. At most limit events are returned; if before is non-zero
+// only events with an ID lower than before are returned, for pagination.
+func (db DB) SelectViolationEvents(ip string, before int64, limit int) (ret []ViolationEvent, err error) {
+	defer dbTiming("select_violation_events", time.Now())
+	rows, err := db.Query("SELECT id, ip, violation, penalty, reputation, credential, created "+
+		"FROM violation_event WHERE ip <<= $1 AND ($2 = 0 OR id < $2) "+
+		"ORDER BY id DESC LIMIT $3", ip, before, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			ns  sql.NullString
+			ent ViolationEvent
+		)
+		err = rows.Scan(&ent.ID, &ent.IP, &ent.Violation, &ent.Penalty, &ent.Reputation, &ns,
+			&ent.Created)
+		if err != nil {
+			return
+		}
+		ent.Credential = ns.String
+		ret = append(ret, ent)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteViolationEventsBefore removes violation history older than t, returning the number of
+// events removed
+func (db DB) DeleteViolationEventsBefore(tx *sql.Tx, t time.Time) (int64, error) {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("DELETE FROM violation_event WHERE created < $1;", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// InsertHawkNonce records the nonce of a Hawk request authenticated with the credential ID and
+// timestamp t. It returns false if the nonce was already recorded for the credential.
+func (db DB) InsertHawkNonce(tx *sql.Tx, id string, nonce string, t time.Time) (bool, error) {
+	defer dbTiming("insert_hawk_nonce", time.Now())
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("INSERT INTO hawk_nonce (credential, nonce, timestamp) VALUES ($1, $2, $3) "+
+		"ON CONFLICT DO NOTHING;", id, nonce, t)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteHawkNoncesBefore removes Hawk nonces with a timestamp before t, returning the number of
+// nonces removed
+func (db DB) DeleteHawkNoncesBefore(tx *sql.Tx, t time.Time) (int64, error) {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("DELETE FROM hawk_nonce WHERE timestamp < $1;", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CapReputations lowers the reputation of each of ips to at most max, inserting entries for
+// addresses that have none. Addresses covered by an exception are skipped. It returns the
+// number of entries inserted or lowered.
+func (db DB) CapReputations(tx *sql.Tx, ips []string, max uint) (int64, error) {
+	defer dbTiming("cap_reputations", time.Now())
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	if ips == nil {
+		ips = []string{}
+	}
+	res, err := exec("INSERT INTO reputation (ip, reputation) "+
+		"SELECT DISTINCT t.ip, $2::int FROM unnest($1::iprange[]) AS t(ip) "+
+		"WHERE NOT EXISTS (SELECT 1 FROM exception e WHERE t.ip <<= e.ip) "+
+		"ON CONFLICT (ip) DO UPDATE SET reputation = $2, modified = now() "+
+		"WHERE reputation.reputation > $2;",
+		pq.Array(ips), max)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 // SelectSmallestMatchingSubnet returns the smallest subnet in the database that contains the IP
 // passed as a parameter.
 func (db DB) SelectSmallestMatchingSubnet(ip string) (ReputationEntry, error) {
+	defer dbTiming("select_smallest_matching_subnet", time.Now())
 	var entry ReputationEntry
 	err := db.reputationSelectStmt.QueryRow(ip).Scan(&entry.IP, &entry.Reputation, &entry.Reviewed)
 	return entry, err
 }
 
+// SelectSmallestMatchingSubnets looks up the smallest subnet in the database that contains each
+// of ips in a single query. The results are in the same order as ips.
+func (db DB) SelectSmallestMatchingSubnets(ips []string) (ret []ReputationLookup, err error) {
+	defer dbTiming("select_smallest_matching_subnets", time.Now())
+	rows, err := db.Query("SELECT q.n, r.ip::text, r.reputation, r.reviewed, "+
+		"e.ip::text, e.modified, e.expires, e.creator, e.reason "+
+		"FROM unnest($1::iprange[]) WITH ORDINALITY AS q(ip, n) "+
+		"LEFT JOIN LATERAL (SELECT ip, reputation, reviewed FROM reputation "+
+		"WHERE ip >>= q.ip ORDER BY @ ip LIMIT 1) r ON true "+
+		"LEFT JOIN LATERAL (SELECT ip, modified, expires, creator, reason FROM exception "+
+		"WHERE q.ip <<= ip ORDER BY @ ip LIMIT 1) e ON true "+
+		"ORDER BY q.n", pq.Array(ips))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			l          ReputationLookup
+			n          int
+			subnet     sql.NullString
+			reputation sql.NullInt64
+			reviewed   sql.NullBool
+			except     sql.NullString
+			modified   pq.NullTime
+			expires    pq.NullTime
+			creator    sql.NullString
+			reason     sql.NullString
+		)
+		err = rows.Scan(&n, &subnet, &reputation, &reviewed, &except, &modified, &expires, &creator,
+			&reason)
+		if err != nil {
+			return
+		}
+		l.IP = ips[n-1]
+		switch {
+		case except.Valid:
+			l.Status = LookupExcepted
+			l.Reputation = 100
+			l.Exception = &ExceptionEntry{
+				IP:       except.String,
+				Creator:  creator.String,
+				Modified: modified.Time,
+				Expires:  expires.Time,
+				Reason:   reason.String,
+			}
+		case subnet.Valid:
+			l.Status = LookupFound
+			l.Subnet = subnet.String
+			l.Reputation = uint(reputation.Int64)
+			l.Reviewed = reviewed.Bool
+		default:
+			l.Status = LookupUnknown
+			l.Reputation = 100
+		}
+		ret = append(ret, l)
+	}
+	err = rows.Err()
+	return
+}
+
 // DeleteReputationEntry deletes an entry from the database based on the entry's IP address
 func (db DB) DeleteReputationEntry(tx *sql.Tx, entry ReputationEntry) error {
+	defer dbTiming("delete_reputation_entry", time.Now())
 	exec := db.Exec
 	if tx != nil {
 		exec = tx.Exec
@@ -309,6 +510,7 @@ func (db DB) DeleteReputationEntry(tx *sql.Tx, entry ReputationEntry) error {
 // InsertOrUpdateExceptionEntry inserts a single ExceptionEntry into the database, and if it already exists,
 // it updates it
 func (db DB) InsertOrUpdateExceptionEntry(tx *sql.Tx, entry ExceptionEntry) error {
+	defer dbTiming("insert_or_update_exception_entry", time.Now())
 	exec := db.Exec
 	if tx != nil {
 		exec = tx.Exec
@@ -319,9 +521,10 @@ func (db DB) InsertOrUpdateExceptionEntry(tx *sql.Tx, entry ExceptionEntry) erro
 		nt.Valid = true
 		nt.Time = entry.Expires
 	}
-	_, err := exec("INSERT INTO exception (ip, modified, expires, creator) VALUES ($1, now(), $2, $3) "+
-		"ON CONFLICT (ip, creator) DO UPDATE SET expires = $2, modified = now();",
-		entry.IP, nt, entry.Creator)
+	_, err := exec("INSERT INTO exception (ip, modified, expires, creator, reason) "+
+		"VALUES ($1, now(), $2, $3, $4) "+
+		"ON CONFLICT (ip, creator) DO UPDATE SET expires = $2, reason = $4, modified = now();",
+		entry.IP, nt, entry.Creator, entry.Reason)
 	return err
 }
 
@@ -338,58 +541,168 @@ func (db DB) DeleteExceptionCreatorType(tx *sql.Tx, creatorType string) error {
 	return err
 }
 
+// DeleteException removes exceptions for exactly ip where the creator begins with
+// creatorType. It returns ErrNoRowsAffected if there were no such exceptions.
+func (db DB) DeleteException(tx *sql.Tx, ip string, creatorType string) error {
+	defer dbTiming("delete_exception", time.Now())
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("DELETE FROM exception WHERE ip = $1 AND creator LIKE $2", ip, creatorType+"%")
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// ReplaceCreatorExceptions replaces the exceptions created by creator with entries, removing
+// any that are not in entries. It should be called with a transaction so the exceptions are
+// replaced atomically.
+func (db DB) ReplaceCreatorExceptions(tx *sql.Tx, creator string, entries []ExceptionEntry) error {
+	defer dbTiming("replace_creator_exceptions", time.Now())
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	ips := []string{}
+	for _, e := range entries {
+		ips = append(ips, e.IP)
+	}
+	_, err := exec("DELETE FROM exception WHERE creator = $1 AND NOT (ip = ANY($2::iprange[]));",
+		creator, pq.Array(ips))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = db.InsertOrUpdateExceptionEntry(tx, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteExpiredExceptions removes any exception from the exception table that has expired
 func (db DB) DeleteExpiredExceptions(tx *sql.Tx) error {
+	return db.DeleteExpiredExceptionsExcept(tx, nil)
+}
+
+// DeleteExpiredExceptionsExcept removes any exception from the exception table that has
+// expired, other than exceptions created by one of creators
+func (db DB) DeleteExpiredExceptionsExcept(tx *sql.Tx, creators []string) error {
+	defer dbTiming("delete_expired_exceptions", time.Now())
 	exec := db.Exec
 	if tx != nil {
 		exec = tx.Exec
 	}
-	_, err := exec("DELETE FROM exception WHERE expires IS NOT NULL AND expires < now();")
+	// A nil array is sent as NULL, which would never match
+	if creators == nil {
+		creators = []string{}
+	}
+	_, err := exec("DELETE FROM exception WHERE expires IS NOT NULL AND expires < now() "+
+		"AND NOT (creator = ANY($1));", pq.Array(creators))
 	return err
 }
 
 // SelectExceptionsContaining returns any exceptions that apply to IP, or an empty slice if
 // none were found.
 func (db DB) SelectExceptionsContaining(ip string) (ret []ExceptionEntry, err error) {
-	rows, err := db.Query("SELECT ip, modified, expires, creator FROM exception "+
+	defer dbTiming("select_exceptions_containing", time.Now())
+	rows, err := db.Query("SELECT ip, modified, expires, creator, reason FROM exception "+
 		"WHERE $1 <<= ip", ip)
 	if err != nil {
 		return
 	}
+	return scanExceptionEntries(rows)
+}
+
+// SelectSmallestMatchingException returns the smallest exception that contains ip, or nil if
+// there is none
+func (db DB) SelectSmallestMatchingException(ip string) (*ExceptionEntry, error) {
+	defer dbTiming("select_smallest_matching_exception", time.Now())
+	rows, err := db.Query("SELECT ip, modified, expires, creator, reason FROM exception "+
+		"WHERE $1 <<= ip ORDER BY @ ip LIMIT 1", ip)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := scanExceptionEntries(rows)
+	if err != nil || len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+// SelectExceptionsContainedBy returns any exceptions contained within subnet
+func (db DB) SelectExceptionsContainedBy(subnet string) (ret []ExceptionEntry, err error) {
+	defer dbTiming("select_exceptions_contained_by", time.Now())
+	rows, err := db.Query("SELECT ip, modified, expires, creator, reason FROM exception "+
+		"WHERE (expires > now() OR expires IS NULL) AND $1 >>= ip", subnet)
+	if err != nil {
+		return
+	}
+	return scanExceptionEntries(rows)
+}
+
+// SelectAllExceptions returns all active exceptions, for both address families
+func (db DB) SelectAllExceptions() (ret []ExceptionEntry, err error) {
+	defer dbTiming("select_all_exceptions", time.Now())
+	rows, err := db.Query("SELECT ip, modified, expires, creator, reason FROM exception " +
+		"WHERE (expires > now() OR expires IS NULL)")
+	if err != nil {
+		return
+	}
+	return scanExceptionEntries(rows)
+}
+
+// CountReputationEntries returns the number of entries in the reputation table
+func (db DB) CountReputationEntries() (n int64, err error) {
+	err = db.QueryRow("SELECT count(*) FROM reputation").Scan(&n)
+	return
+}
+
+// CountExceptionsByCreator returns the number of active exceptions for each creator
+func (db DB) CountExceptionsByCreator() (ret map[string]int64, err error) {
+	rows, err := db.Query("SELECT creator, count(*) FROM exception " +
+		"WHERE (expires > now() OR expires IS NULL) GROUP BY creator")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	ret = make(map[string]int64)
 	for rows.Next() {
 		var (
-			nt  pq.NullTime
-			ent ExceptionEntry
+			creator string
+			n       int64
 		)
-		err = rows.Scan(&ent.IP, &ent.Modified, &nt, &ent.Creator)
+		err = rows.Scan(&creator, &n)
 		if err != nil {
-			rows.Close()
 			return
 		}
-		if nt.Valid {
-			ent.Expires = nt.Time
-		}
-		ret = append(ret, ent)
+		ret[creator] = n
 	}
 	err = rows.Err()
 	return
 }
 
-// SelectExceptionsContainedBy returns any exceptions contained within subnet
-func (db DB) SelectExceptionsContainedBy(subnet string) (ret []ExceptionEntry, err error) {
-	rows, err := db.Query("SELECT ip, modified, expires, creator FROM exception "+
-		"WHERE (expires > now() OR expires IS NULL) AND $1 >>= ip", subnet)
-	if err != nil {
-		return
-	}
+// scanExceptionEntries reads ExceptionEntry rows selected as (ip, modified, expires, creator)
+// and closes rows
+func scanExceptionEntries(rows *sql.Rows) (ret []ExceptionEntry, err error) {
+	defer rows.Close()
 	for rows.Next() {
 		var (
 			nt  pq.NullTime
 			ent ExceptionEntry
 		)
-		err = rows.Scan(&ent.IP, &ent.Modified, &nt, &ent.Creator)
+		err = rows.Scan(&ent.IP, &ent.Modified, &nt, &ent.Creator, &ent.Reason)
 		if err != nil {
-			rows.Close()
 			return
 		}
 		if nt.Valid {
@@ -401,18 +714,15 @@ func (db DB) SelectExceptionsContainedBy(subnet string) (ret []ExceptionEntry, e
 	return
 }
 
-// SelectAllExceptions returns all active exceptions
-func (db DB) SelectAllExceptions() (ret []ExceptionEntry, err error) {
-	return db.SelectExceptionsContainedBy("0.0.0.0/0")
-}
-
 // SetReviewedFlag sets the reviewed boolean flag on a reputation entry in the database
 func (db DB) SetReviewedFlag(tx *sql.Tx, entry ReputationEntry, f bool) error {
+	defer dbTiming("set_reviewed_flag", time.Now())
 	exec := db.Exec
 	if tx != nil {
 		exec = tx.Exec
 	}
-	res, err := exec("UPDATE reputation SET reviewed = $1 WHERE ip = $2;", f, entry.IP)
+	res, err := exec("UPDATE reputation SET reviewed = $1, modified = now() WHERE ip = $2;", f,
+		entry.IP)
 	if err != nil {
 		return err
 	}
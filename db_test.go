@@ -37,6 +37,63 @@ func TestCreateSchema(t *testing.T) {
 	assert.Nil(t, err, "Running CreateTables when the tables already exist shouldn't error")
 }
 
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "Migrations must be numbered consecutively from 1")
+		assert.NotEmpty(t, m.Description)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	assert.Nil(t, testDB.MigrateUp())
+	version, err := testDB.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+
+	for v := LatestSchemaVersion(); v > 0; v-- {
+		assert.Nil(t, testDB.MigrateDown())
+		version, err = testDB.SchemaVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, v-1, version)
+	}
+	assert.NotNil(t, testDB.MigrateDown(), "Reverting with no applied migrations should fail")
+	status, err := testDB.MigrationStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), len(status))
+	for _, s := range status {
+		assert.True(t, s.Applied.IsZero())
+	}
+
+	assert.Nil(t, testDB.MigrateUp())
+	version, err = testDB.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+	status, err = testDB.MigrationStatus()
+	assert.Nil(t, err)
+	for _, s := range status {
+		assert.False(t, s.Applied.IsZero())
+	}
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "2001:db8::1", Reputation: 50})
+	assert.Nil(t, err)
+	assert.Nil(t, testDB.EmptyTables())
+}
+
+func TestNewDBRefusesNewerSchema(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	newer := LatestSchemaVersion() + 1
+	_, err := testDB.Exec("INSERT INTO schema_version (version, description) VALUES ($1, 'future')", newer)
+	assert.Nil(t, err)
+	_, err = NewDB(dsn)
+	assert.NotNil(t, err)
+	assert.NotNil(t, testDB.MigrateUp())
+	_, err = testDB.Exec("DELETE FROM schema_version WHERE version = $1", newer)
+	assert.Nil(t, err)
+	assert.Nil(t, testDB.MigrateUp())
+}
+
 func TestReputationUpdateConstraint(t *testing.T) {
 	_, err := testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "240.0.0.1", Reputation: 500})
 	assert.IsType(t, CheckViolationError{}, err)
@@ -137,6 +194,91 @@ func TestUpdate(t *testing.T) {
 	assert.Equal(t, uint(1), entry.Reputation)
 }
 
+func TestIPv6Reputation(t *testing.T) {
+	assert.Nil(t, testDB.EmptyTables())
+
+	_, err := testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "2001:db8::/32", Reputation: 50})
+	assert.Nil(t, err)
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "2001:db8:1::/48", Reputation: 20})
+	assert.Nil(t, err)
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "192.168.0.0/16", Reputation: 10})
+	assert.Nil(t, err)
+
+	entry, err := testDB.SelectSmallestMatchingSubnet("2001:db8:1::1/128")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8:1::/48", entry.IP)
+	assert.Equal(t, uint(20), entry.Reputation)
+
+	entry, err = testDB.SelectSmallestMatchingSubnet("2001:db8:2::1/128")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::/32", entry.IP)
+	assert.Equal(t, uint(50), entry.Reputation)
+
+	_, err = testDB.SelectSmallestMatchingSubnet("2001:db9::1/128")
+	assert.NotNil(t, err)
+
+	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "2001:db8:1::1/128", Violation: "test", Penalty: 30}})
+	assert.Nil(t, err)
+	entry, err = testDB.SelectSmallestMatchingSubnet("2001:db8:1::1/128")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(70), entry.Reputation)
+
+	assert.Nil(t, testDB.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "2001:db8:3::/48",
+		Creator: "file:/test",
+	}))
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "2001:db8:3::1/128", Reputation: 0})
+	assert.Equal(t, ErrNoRowsAffected, err)
+	ret, err := testDB.SelectExceptionsContaining("2001:db8:3::1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	ret, err = testDB.SelectAllExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+
+	assert.Nil(t, testDB.DeleteReputationEntry(nil, ReputationEntry{IP: "2001:db8:1::/48"}))
+	entry, err = testDB.SelectSmallestMatchingSubnet("2001:db8:1::5/128")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::/32", entry.IP)
+}
+
+func TestMigrateIP4RColumns(t *testing.T) {
+	// Revert to schema version 3, when addresses were still stored as ip4r
+	assert.Nil(t, testDB.CreateTables())
+	assert.Nil(t, testDB.EmptyTables())
+	version, err := testDB.SchemaVersion()
+	assert.Nil(t, err)
+	for ; version > 3; version-- {
+		assert.Nil(t, testDB.MigrateDown())
+	}
+	_, err = testDB.Exec("INSERT INTO reputation (ip, reputation) VALUES ('10.0.0.0/8', 40);" +
+		"INSERT INTO exception (ip, modified, creator) VALUES ('192.168.0.0/16', now(), 'file:/test');")
+	assert.Nil(t, err)
+	assert.Nil(t, testDB.CreateTables())
+	version, err = testDB.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+
+	var udt string
+	err = testDB.QueryRow("SELECT udt_name FROM information_schema.columns " +
+		"WHERE table_name = 'reputation' AND column_name = 'ip'").Scan(&udt)
+	assert.Nil(t, err)
+	assert.Equal(t, "iprange", udt)
+	err = testDB.QueryRow("SELECT udt_name FROM information_schema.columns " +
+		"WHERE table_name = 'exception' AND column_name = 'ip'").Scan(&udt)
+	assert.Nil(t, err)
+	assert.Equal(t, "iprange", udt)
+
+	entry, err := testDB.SelectSmallestMatchingSubnet("10.1.2.3")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(40), entry.Reputation)
+	ret, err := testDB.SelectExceptionsContaining("192.168.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	assert.Nil(t, testDB.EmptyTables())
+}
+
 func TestDelete(t *testing.T) {
 	assert.Nil(t, testDB.EmptyTables())
 	_, err := testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "192.168.0.1", Reputation: 0})
@@ -151,7 +293,8 @@ func TestInsertOrUpdateReputationPenalties(t *testing.T) {
 	assert.Nil(t, testDB.EmptyTables())
 
 	// test insert
-	_, err := testDB.InsertOrUpdateReputationPenalties(nil, []string{"192.168.0.1"}, []uint{90})
+	_, err := testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "192.168.0.1", Violation: "test", Penalty: 90}})
 	assert.Nil(t, err)
 
 	entry, err := testDB.SelectSmallestMatchingSubnet("192.168.0.1")
@@ -159,7 +302,8 @@ func TestInsertOrUpdateReputationPenalties(t *testing.T) {
 	assert.Equal(t, uint(10), entry.Reputation)
 
 	// test update
-	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []string{"192.168.0.1"}, []uint{9})
+	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "192.168.0.1", Violation: "test", Penalty: 9}})
 	assert.Nil(t, err)
 
 	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
@@ -167,7 +311,8 @@ func TestInsertOrUpdateReputationPenalties(t *testing.T) {
 	assert.Equal(t, uint(1), entry.Reputation)
 
 	// test reputation doesn't go negative
-	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []string{"192.168.0.1"}, []uint{90})
+	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "192.168.0.1", Violation: "test", Penalty: 90}})
 	assert.Nil(t, err)
 
 	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
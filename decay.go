@@ -0,0 +1,145 @@
+package tigerblood
+
+import (
+	"context"
+	"database/sql"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// decayLockID is the postgres advisory lock key held by the replica currently responsible
+// for decaying reputations
+const decayLockID = 0x6465636179 // "decay"
+
+// decayConfig controls how reputations recover over time
+type decayConfig struct {
+	rate            uint          // Default reputation points restored each interval
+	interval        time.Duration // How often decay is applied
+	deleteRecovered bool          // Delete entries once they reach 100
+}
+
+// DecayReputations restores reputations towards 100. Recorded violation penalties with a
+// recovery period are restored linearly over that period. Entries with no such outstanding
+// penalties are raised by rate, capped at 100. Penalty records are removed once fully restored
+// or once the reputation reaches 100, and if deleteRecovered is true, entries that have reached
+// 100 are removed. It returns the number of entries decayed and deleted.
+func (db DB) DecayReputations(tx *sql.Tx, rate uint, deleteRecovered bool) (decayed int64, deleted int64, err error) {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("WITH target AS (SELECT ctid, recovered AS old, LEAST(penalty, " +
+		"FLOOR(penalty * EXTRACT(EPOCH FROM now() - applied) / EXTRACT(EPOCH FROM recovery)))::int " +
+		"AS recovered FROM reputation_penalty WHERE recovery IS NOT NULL AND recovered < penalty), " +
+		"restored AS (UPDATE reputation_penalty p SET recovered = target.recovered FROM target " +
+		"WHERE p.ctid = target.ctid AND target.recovered > target.old " +
+		"RETURNING p.ip, target.recovered - target.old AS amount) " +
+		"UPDATE reputation SET reputation = LEAST(100, reputation.reputation + r.amount) " +
+		"FROM (SELECT ip, SUM(amount) AS amount FROM restored GROUP BY ip) r " +
+		"WHERE reputation.ip = r.ip;")
+	if err != nil {
+		return
+	}
+	decayed, err = res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if rate > 0 {
+		res, err = exec("UPDATE reputation SET reputation = LEAST(100, reputation + $1) "+
+			"WHERE reputation < 100 AND NOT EXISTS (SELECT 1 FROM reputation_penalty p "+
+			"WHERE p.ip = reputation.ip AND p.recovery IS NOT NULL AND p.recovered < p.penalty);", rate)
+		if err != nil {
+			return
+		}
+		var n int64
+		n, err = res.RowsAffected()
+		if err != nil {
+			return
+		}
+		decayed += n
+	}
+	_, err = exec("DELETE FROM reputation_penalty p USING reputation r WHERE p.ip = r.ip " +
+		"AND (r.reputation >= 100 OR (p.recovery IS NOT NULL AND p.recovered >= p.penalty));")
+	if err != nil || !deleteRecovered {
+		return
+	}
+	res, err = exec("DELETE FROM reputation WHERE reputation >= 100;")
+	if err != nil {
+		return
+	}
+	deleted, err = res.RowsAffected()
+	return
+}
+
+// StartDecay starts a routine that applies DecayReputations every interval. Every replica
+// may call StartDecay; only the replica holding the decay advisory lock applies decay, and
+// another replica takes over if its database connection is lost. A rate of 0 disables the
+// default decay so only violations with a recovery period recover. If deleteRecovered is true,
+// entries that have recovered to 100 are deleted.
+func StartDecay(rate uint, interval time.Duration, deleteRecovered bool) {
+	if rate > 100 || interval <= 0 {
+		log.Fatalf("Invalid decay configuration: rate %d interval %s", rate, interval)
+	}
+	cfg := decayConfig{
+		rate:            rate,
+		interval:        interval,
+		deleteRecovered: deleteRecovered,
+	}
+	go func() {
+		log.Printf("Starting reputation decay routine (rate %d every %s)", rate, interval)
+		for {
+			err := runDecayLeader(cfg)
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Reputation decay failed: %s", err)
+			}
+			time.Sleep(cfg.interval)
+		}
+	}()
+}
+
+// runDecayLeader tries to become the decay leader, and if successful applies decay every
+// interval until an error occurs. It returns nil without doing anything if another replica
+// is the leader.
+func runDecayLeader(cfg decayConfig) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var leader bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", decayLockID).Scan(&leader)
+	if err != nil || !leader {
+		return err
+	}
+	// Release the lock before the connection is returned to the pool; if the connection
+	// is broken postgres releases it when the session ends
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", decayLockID)
+	log.Print("Acquired reputation decay lock")
+
+	for {
+		time.Sleep(cfg.interval)
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		decayed, deleted, err := db.DecayReputations(tx, cfg.rate, cfg.deleteRecovered)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"decayed": decayed,
+			"deleted": deleted,
+		}).Infof("reputation decay applied")
+		if statsdClient != nil {
+			statsdClient.Count("decay.rows_decayed", decayed, nil, 1)
+			statsdClient.Count("decay.rows_deleted", deleted, nil, 1)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDecayReputations(t *testing.T) {
+	assert.Nil(t, testDB.EmptyTables())
+	_, err := testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "192.168.0.1", Reputation: 10})
+	assert.Nil(t, err)
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "192.168.0.2", Reputation: 95, Reviewed: true})
+	assert.Nil(t, err)
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "2001:db8::1", Reputation: 100})
+	assert.Nil(t, err)
+
+	decayed, deleted, err := testDB.DecayReputations(nil, 10, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), decayed)
+	assert.Equal(t, int64(0), deleted)
+	entry, err := testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(20), entry.Reputation)
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), entry.Reputation, "Decay should be capped at 100")
+	assert.Equal(t, false, entry.Reviewed, "Reviewed flag should reset on recovery")
+
+	decayed, deleted, err = testDB.DecayReputations(nil, 10, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), decayed)
+	assert.Equal(t, int64(2), deleted)
+	_, err = testDB.SelectSmallestMatchingSubnet("192.168.0.2")
+	assert.NotNil(t, err)
+	_, err = testDB.SelectSmallestMatchingSubnet("2001:db8::1")
+	assert.NotNil(t, err)
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(30), entry.Reputation)
+	assert.Nil(t, testDB.EmptyTables())
+}
+
+func TestDecayKeepsModified(t *testing.T) {
+	assert.Nil(t, testDB.EmptyTables())
+	for _, ip := range []string{"192.168.0.1", "192.168.0.2"} {
+		_, err := testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: ip, Reputation: 10})
+		assert.Nil(t, err)
+	}
+	_, err := testDB.Exec("UPDATE reputation SET modified = now() - interval '1 hour'")
+	assert.Nil(t, err)
+	since := time.Now().Add(-time.Minute)
+
+	decayed, _, err := testDB.DecayReputations(nil, 10, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), decayed)
+	entries, err := testDB.SelectReputationEntries(ReputationFilter{MaxReputation: 100, ModifiedSince: since}, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, entries, "Decay should not change the modification time")
+	entries, err = testDB.SelectReputationEntries(ReputationFilter{MaxReputation: 100, ModifiedBefore: since}, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Nil(t, testDB.SetReviewedFlag(nil, ReputationEntry{IP: "192.168.0.2"}, true))
+	entries, err = testDB.SelectReputationEntries(ReputationFilter{MaxReputation: 100, ModifiedSince: since}, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "192.168.0.2", entries[0].IP)
+		assert.Equal(t, uint(20), entries[0].Reputation)
+	}
+	assert.Nil(t, testDB.EmptyTables())
+}
+
+func TestDecayViolationRecovery(t *testing.T) {
+	assert.Nil(t, testDB.EmptyTables())
+	ret, err := testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "192.168.0.1", Violation: "test:slow", Penalty: 60, Recovery: 4 * time.Hour},
+		{IP: "192.168.0.2", Violation: "test:default", Penalty: 60},
+		{IP: "192.168.0.3", Violation: "test:fast", Penalty: 50, Recovery: time.Hour},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{40, 40, 50}, ret)
+	// A second penalty can only lower the reputation by what is left, so only 50 is recorded
+	_, err = testDB.InsertOrUpdateReputationPenalties(nil, []ReputationPenalty{
+		{IP: "192.168.0.3", Violation: "test:fast", Penalty: 80, Recovery: time.Hour},
+	})
+	assert.Nil(t, err)
+	var penalty uint
+	assert.Nil(t, testDB.QueryRow("SELECT SUM(penalty) FROM reputation_penalty WHERE ip = '192.168.0.3'").Scan(&penalty))
+	assert.Equal(t, uint(100), penalty)
+
+	_, err = testDB.Exec("UPDATE reputation_penalty SET applied = now() - interval '1 hour'")
+	assert.Nil(t, err)
+	decayed, _, err := testDB.DecayReputations(nil, 5, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), decayed)
+
+	// A quarter of the 4 hour recovery period has passed
+	entry, err := testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(55), entry.Reputation)
+	// No recovery period, so the default rate applies
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(45), entry.Reputation)
+	// Fully recovered
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.3")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), entry.Reputation)
+	var count int
+	assert.Nil(t, testDB.QueryRow("SELECT COUNT(*) FROM reputation_penalty WHERE ip = '192.168.0.3'").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	// Running again without time passing restores nothing more from the recorded penalty
+	_, _, err = testDB.DecayReputations(nil, 5, false)
+	assert.Nil(t, err)
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(55), entry.Reputation)
+
+	// Setting the reputation directly discards recorded penalties
+	_, err = testDB.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "192.168.0.1", Reputation: 10})
+	assert.Nil(t, err)
+	assert.Nil(t, testDB.QueryRow("SELECT COUNT(*) FROM reputation_penalty WHERE ip = '192.168.0.1'").Scan(&count))
+	assert.Equal(t, 0, count)
+	_, _, err = testDB.DecayReputations(nil, 5, false)
+	assert.Nil(t, err)
+	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(15), entry.Reputation)
+	assert.Nil(t, testDB.EmptyTables())
+}
@@ -29,6 +29,8 @@ const (
 	HawkInvalidBodyHash
 	// HawkReadBodyError error reading the request body
 	HawkReadBodyError
+	// HawkNonceStoreFull the nonce store could not record the nonce without allowing replays
+	HawkNonceStoreFull
 )
 
 // missing global errors usually result in warnings or 500 errors
@@ -63,6 +65,12 @@ const (
 	TooManyIPViolationEntriesError
 	// DuplicateIPError when the same IP occurs in multiple entries
 	DuplicateIPError
+	// InvalidParameterError query parameter validation failure
+	InvalidParameterError
+	// TooManyIPsError too many IPs in a bulk request
+	TooManyIPsError
+	// ExceptedIPError a write was not applied because an exception covers the IP
+	ExceptedIPError
 )
 
 // missing parameter errors usually result in a 400 error
@@ -92,7 +100,23 @@ const (
 	// APIKeyNotSpecified indicates the header value was not found
 	APIKeyNotSpecified = 70 + iota
 	// APIKeyInvalid indicates the key was not a configured credential
-	APIKeyInvalid = iota
+	APIKeyInvalid
+	// APIKeyInactive indicates the key matched but is outside its not-before/not-after dates
+	APIKeyInactive
+)
+
+// mTLS authentication errors
+const (
+	// MTLSNoCertificate indicates the request had no verified client certificate
+	MTLSNoCertificate = 90 + iota
+	// MTLSUnknownCertificate indicates the client certificate did not map to a principal
+	MTLSUnknownCertificate
+)
+
+// Authorization errors usually result in a 403 error
+const (
+	// InsufficientScopeError the credential was not granted the scope a request needs
+	InsufficientScopeError = 80 + iota
 )
 
 // UnknownError is for generic errors
@@ -118,6 +142,12 @@ func DescribeErrno(errno Errno) string {
 		return "Too many IP, violation objects in request body"
 	case DuplicateIPError:
 		return "Duplicate IP found in multiple entries: %s"
+	case InvalidParameterError:
+		return "Invalid %s parameter: %s"
+	case TooManyIPsError:
+		return "Too many IPs in request body (maximum %d)"
+	case ExceptedIPError:
+		return "IP %s is covered by exception %s created by %s"
 
 	case MissingIPError:
 		return "Error finding IP parameter"
@@ -140,6 +170,9 @@ func DescribeErrno(errno Errno) string {
 	case FileNotFound:
 		return "Error finding file %s: %s"
 
+	case InsufficientScopeError:
+		return "Credential %s was not granted the %s scope"
+
 	default:
 		return "Error: %s"
 	}
@@ -20,6 +20,9 @@ var ett = []errnoTestTable{
 	{InvalidReputationError, "Invalid reputation: test", []interface{}{"test"}},
 	{InvalidViolationTypeError, "Invalid violation type: test", []interface{}{"test"}},
 	{TooManyIPViolationEntriesError, "Too many IP, violation objects in request body", []interface{}{}},
+	{TooManyIPsError, "Too many IPs in request body (maximum 10)", []interface{}{10}},
+	{ExceptedIPError, "IP 10.0.0.1 is covered by exception 10.0.0.0/8 created by api:test",
+		[]interface{}{"10.0.0.1", "10.0.0.0/8", "api:test"}},
 	{MissingIPError, "Error finding IP parameter", []interface{}{}},
 	{MissingReputationError, "Error finding reputation parameter in test: reputation",
 		[]interface{}{"test", "reputation"}},
@@ -32,9 +35,17 @@ var ett = []errnoTestTable{
 	{MissingStatsdClient, "Could not find statsdClient", []interface{}{}},
 	{CWDNotFound, "Error getting CWD: test", []interface{}{"test"}},
 	{FileNotFound, "Error finding file path: test", []interface{}{"path", "test"}},
+	{InsufficientScopeError, "Credential reporter was not granted the admin scope",
+		[]interface{}{"reporter", ScopeAdmin}},
 	{UnknownError, "Error: test", []interface{}{"test"}},
 }
 
+func TestAPIKeyErrnos(t *testing.T) {
+	assert.Equal(t, Errno(70), Errno(APIKeyNotSpecified))
+	assert.Equal(t, Errno(71), Errno(APIKeyInvalid))
+	assert.Equal(t, Errno(72), Errno(APIKeyInactive))
+}
+
 func TestDescribeErrno(t *testing.T) {
 	for _, v := range ett {
 		assert.Equal(t, v.expect, fmt.Sprintf(DescribeErrno(v.errno), v.a...))
@@ -1,14 +1,21 @@
 package tigerblood
 
 import (
-	"bufio"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,17 +26,43 @@ var exceptionTestHook bool
 
 const awsUpdateInterval = time.Minute * 60
 const awsIPRangeURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+const urlUpdateInterval = time.Minute * 60
+const urlMaxSize = 10 << 20
+
+// exceptionFilePollInterval is how often file exception sources are checked for changes, in
+// addition to file system notifications
+const exceptionFilePollInterval = time.Second * 30
+
+// exceptionFileSettleDelay is how long to wait after a file exception source changes before
+// reloading it
+const exceptionFileSettleDelay = time.Second
+
+// exceptionRetryMin is the delay before retrying a dynamic exception source after its first
+// failed update
+const exceptionRetryMin = time.Minute
+
+// exceptionHTTPClient is used to fetch exception data from AWS and URL sources
+var exceptionHTTPClient = &http.Client{Timeout: time.Minute}
 
 // awsIPRanges is used to unmarshal the data we should get from awsIPRangeURL
 type awsIPRanges struct {
 	Prefixes []struct {
 		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
 	} `json:"prefixes"`
 	IPV6Prefixes []struct {
 		IPV6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
 	} `json:"ipv6_prefixes"`
 }
 
+// exceptionAPICreatorPrefix is the creator prefix of exceptions added through the API. It is
+// not an exception source, so these exceptions are not purged on initialization and are
+// kept until they expire or are deleted through the API.
+const exceptionAPICreatorPrefix = "api"
+
 // ExceptionSource is an interface defining generic functions that all sources of
 // exception information must implement
 type exceptionSource interface {
@@ -40,12 +73,20 @@ type exceptionSource interface {
 	updateInterval() *time.Duration
 }
 
+// exceptionPenaltySource is implemented by exception sources that can be configured to give
+// their addresses a standing reputation penalty instead of excepting them
+type exceptionPenaltySource interface {
+	standingPenalty() uint // The penalty to apply, or 0 to except the addresses
+}
+
 // allExceptionTypes should contain a list of all types that represent a source of
 // exception information, and is used by housekeeping operations for example to
 // identify static exception sources and purge old database entries on initialization
 var allExceptionTypes = []exceptionSource{
 	&exceptionFile{},
 	&exceptionAWS{},
+	&exceptionURL{},
+	&exceptionTor{},
 }
 
 // exceptionCalcExpiry is a helper function to calculate when an exception should expire
@@ -78,41 +119,168 @@ func (e *exceptionFile) updateInterval() *time.Duration {
 	return nil
 }
 
+// getExceptions reads the file, which contains one address or CIDR per line. Blank lines and
+// comments starting with # are ignored, and invalid lines are logged and skipped.
 func (e *exceptionFile) getExceptions() (ret []ExceptionEntry, err error) {
-	fd, err := os.Open(e.Path)
+	buf, err := ioutil.ReadFile(e.Path)
 	if err != nil {
 		return
 	}
-	defer fd.Close()
-
-	scn := bufio.NewScanner(fd)
-	for scn.Scan() {
-		buf := scn.Text()
-		_, _, err = net.ParseCIDR(buf)
+	for _, a := range exceptionTextAddresses(buf) {
+		cidr, err := NormalizeCIDROrIP(a)
 		if err != nil {
-			return
+			log.WithFields(log.Fields{
+				"errno":  InvalidIPError,
+				"source": e.getName(),
+			}).Warnf(DescribeErrno(InvalidIPError), a)
+			continue
 		}
 		ret = append(ret, ExceptionEntry{
 			Creator: e.getName(),
-			IP:      buf,
+			IP:      cidr,
 		})
+	}
+	return ret, nil
+}
 
+// reload reads the file and replaces the exceptions from it in a single transaction, so
+// exceptions removed from the file are removed and a failed reload leaves the previous
+// exceptions in place
+func (e *exceptionFile) reload() error {
+	ent, err := e.getExceptions()
+	if err == nil {
+		var tx *sql.Tx
+		tx, err = db.Begin()
+		if err == nil {
+			err = db.ReplaceCreatorExceptions(tx, e.getName(), ent)
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}
+	}
+	recordExceptionUpdate(e, err)
+	if err != nil {
+		log.WithFields(log.Fields{"source": e.getName()}).Warnf("Error reloading exceptions: %s", err)
+		return err
+	}
+	log.WithFields(log.Fields{"source": e.getName()}).Infof("Reloaded %d exceptions", len(ent))
+	return nil
+}
+
+// watch reloads the file whenever it changes. Changes are detected with file system
+// notifications on the containing directory, so files replaced by renaming are also seen,
+// and by checking the modification time and size every exceptionFilePollInterval in case
+// notifications are unavailable or missed (e.g., when the file is reached through a symlink
+// that is swapped).
+func (e *exceptionFile) watch() {
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(e.Path))
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"source": e.getName()}).Warnf(
+			"File notifications unavailable, polling for changes: %s", err)
+	} else {
+		defer watcher.Close()
+		events = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	target := filepath.Clean(e.Path)
+	last, _ := os.Stat(e.Path)
+	ticker := time.NewTicker(exceptionFilePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-events:
+			if filepath.Clean(ev.Name) != target {
+				continue
+			}
+			// Wait for writes to settle, since files are often written in several steps
+			time.Sleep(exceptionFileSettleDelay)
+		case err := <-watchErrors:
+			log.WithFields(log.Fields{"source": e.getName()}).Warnf("Error watching file: %s", err)
+			continue
+		case <-ticker.C:
+			cur, err := os.Stat(e.Path)
+			if err != nil || (last != nil && cur.ModTime().Equal(last.ModTime()) && cur.Size() == last.Size()) {
+				continue
+			}
+		}
+		last, _ = os.Stat(e.Path)
+		e.reload()
 	}
-	err = scn.Err()
-	return
 }
 
 // exceptionAWS is a type that stores exception information read from the AWS public IP
 // address endpoint (see https://ip-ranges.amazonaws.com/ip-ranges.json)
+//
+// If Regions or Services are set, only prefixes matching one of the listed regions and one
+// of the listed services are included; an empty list matches everything.
 type exceptionAWS struct {
+	Config   string
+	Regions  []string
+	Services []string
+}
+
+// newExceptionAWS parses an AWS exception source configuration, which is a semicolon
+// separated list of key:value filters (e.g., service:CLOUDFRONT;region:us-east-1).
+// Keys may be repeated to match more than one region or service.
+func newExceptionAWS(config string) (*exceptionAWS, error) {
+	ret := &exceptionAWS{Config: config}
+	if config == "" {
+		return ret, nil
+	}
+	for _, f := range strings.Split(config, ";") {
+		tmp := strings.SplitN(f, ":", 2)
+		if len(tmp) != 2 || tmp[1] == "" {
+			return nil, fmt.Errorf("Invalid AWS exception filter %q (format should be key:value)", f)
+		}
+		switch tmp[0] {
+		case "region":
+			ret.Regions = append(ret.Regions, tmp[1])
+		case "service":
+			ret.Services = append(ret.Services, tmp[1])
+		default:
+			return nil, fmt.Errorf("Invalid AWS exception filter key %q", tmp[0])
+		}
+	}
+	return ret, nil
+}
+
+// matchAWSFilter returns true if value matches any entry in filter, or filter is empty
+func matchAWSFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, v := range filter {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *exceptionAWS) matches(region string, service string) bool {
+	return matchAWSFilter(e.Regions, region) && matchAWSFilter(e.Services, service)
 }
 
 func (e *exceptionAWS) getExceptions() (ret []ExceptionEntry, err error) {
-	resp, err := http.Get(awsIPRangeURL)
+	resp, err := exceptionHTTPClient.Get(awsIPRangeURL)
 	if err != nil {
 		return ret, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ret, fmt.Errorf("Unexpected HTTP status fetching %s: %s", awsIPRangeURL, resp.Status)
+	}
 	buf, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return ret, err
@@ -122,19 +290,38 @@ func (e *exceptionAWS) getExceptions() (ret []ExceptionEntry, err error) {
 	if err != nil {
 		return ret, err
 	}
+	return e.entriesFromRanges(awsp), nil
+}
+
+// entriesFromRanges converts the IPv4 and IPv6 prefixes in awsp that match the configured
+// filters into exception entries
+func (e *exceptionAWS) entriesFromRanges(awsp awsIPRanges) (ret []ExceptionEntry) {
+	expires := exceptionCalcExpiry(e)
 	for _, v := range awsp.Prefixes {
-		nent := ExceptionEntry{
+		if !e.matches(v.Region, v.Service) {
+			continue
+		}
+		ret = append(ret, ExceptionEntry{
 			Creator: e.getName(),
 			IP:      v.IPPrefix,
-			Expires: exceptionCalcExpiry(e),
+			Expires: expires,
+		})
+	}
+	for _, v := range awsp.IPV6Prefixes {
+		if !e.matches(v.Region, v.Service) {
+			continue
 		}
-		ret = append(ret, nent)
+		ret = append(ret, ExceptionEntry{
+			Creator: e.getName(),
+			IP:      v.IPV6Prefix,
+			Expires: expires,
+		})
 	}
 	return
 }
 
 func (e *exceptionAWS) getName() string {
-	return e.getCreatorPrefix() + ":"
+	return e.getCreatorPrefix() + ":" + e.Config
 }
 
 func (e *exceptionAWS) getCreatorPrefix() string {
@@ -150,6 +337,322 @@ func (e *exceptionAWS) updateInterval() *time.Duration {
 	return &ret
 }
 
+// exceptionURL is a type that stores exception information periodically fetched from a URL.
+// The document is either a plain text list of addresses, or a JSON document from which
+// addresses are extracted with one or more JSON paths.
+type exceptionURL struct {
+	Config   string
+	URL      string
+	Paths    [][]string    // JSON paths split on dots; if empty the document is plain text
+	Interval time.Duration // Polling interval
+	MaxSize  int64         // Maximum document size in bytes
+
+	etag         string   // ETag of the last document fetched
+	lastModified string   // Last-Modified time of the last document fetched
+	cached       []string // Addresses from the last document fetched
+}
+
+// newExceptionURL parses a URL exception source configuration, which is the URL optionally
+// followed by semicolon separated key:value options (e.g.,
+// https://example.com/ranges.json;path:prefixes.ipv4Prefix;interval:6h;maxsize:1048576).
+// The path key may be repeated to extract addresses from more than one location.
+func newExceptionURL(config string) (*exceptionURL, error) {
+	opts := strings.Split(config, ";")
+	ret := &exceptionURL{
+		Config:   config,
+		URL:      opts[0],
+		Interval: urlUpdateInterval,
+		MaxSize:  urlMaxSize,
+	}
+	u, err := url.Parse(ret.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL exception source %q", ret.URL)
+	}
+	for _, f := range opts[1:] {
+		tmp := strings.SplitN(f, ":", 2)
+		if len(tmp) != 2 || tmp[1] == "" {
+			return nil, fmt.Errorf("Invalid URL exception option %q (format should be key:value)", f)
+		}
+		switch tmp[0] {
+		case "path":
+			ret.Paths = append(ret.Paths, strings.Split(tmp[1], "."))
+		case "interval":
+			ret.Interval, err = time.ParseDuration(tmp[1])
+			if err != nil || ret.Interval <= 0 {
+				return nil, fmt.Errorf("Invalid URL exception interval %q", tmp[1])
+			}
+		case "maxsize":
+			ret.MaxSize, err = strconv.ParseInt(tmp[1], 10, 64)
+			if err != nil || ret.MaxSize <= 0 {
+				return nil, fmt.Errorf("Invalid URL exception maxsize %q", tmp[1])
+			}
+		default:
+			return nil, fmt.Errorf("Invalid URL exception option key %q", tmp[0])
+		}
+	}
+	return ret, nil
+}
+
+func (e *exceptionURL) getName() string {
+	return e.getCreatorPrefix() + ":" + e.Config
+}
+
+func (e *exceptionURL) getCreatorPrefix() string {
+	return "url"
+}
+
+func (e *exceptionURL) isStatic() bool {
+	return false
+}
+
+func (e *exceptionURL) updateInterval() *time.Duration {
+	ret := e.Interval
+	return &ret
+}
+
+func (e *exceptionURL) getExceptions() (ret []ExceptionEntry, err error) {
+	cidrs, err := e.fetch()
+	if err != nil {
+		return
+	}
+	expires := exceptionCalcExpiry(e)
+	for _, cidr := range cidrs {
+		ret = append(ret, ExceptionEntry{
+			Creator: e.getName(),
+			IP:      cidr,
+			Expires: expires,
+		})
+	}
+	return ret, nil
+}
+
+// fetch fetches the document and returns the addresses in it as CIDRs; invalid addresses are
+// logged and skipped. The validators of the previous response are sent so an unchanged
+// document is not downloaded again; if the document has not changed the addresses from the
+// previous fetch are returned.
+func (e *exceptionURL) fetch() (ret []string, err error) {
+	req, err := http.NewRequest("GET", e.URL, nil)
+	if err != nil {
+		return
+	}
+	if e.etag != "" {
+		req.Header.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		req.Header.Set("If-Modified-Since", e.lastModified)
+	}
+	resp, err := exceptionHTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && e.cached != nil {
+		return e.cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ret, fmt.Errorf("Unexpected HTTP status fetching %s: %s", e.URL, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, e.MaxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(buf)) > e.MaxSize {
+		return ret, fmt.Errorf("Document at %s exceeds maximum size of %d bytes", e.URL, e.MaxSize)
+	}
+
+	var addrs []string
+	if len(e.Paths) == 0 {
+		addrs = exceptionTextAddresses(buf)
+	} else {
+		addrs, err = exceptionJSONAddresses(buf, e.Paths)
+		if err != nil {
+			return ret, fmt.Errorf("Error parsing document at %s: %s", e.URL, err)
+		}
+	}
+	ret = []string{}
+	for _, a := range addrs {
+		cidr, err := NormalizeCIDROrIP(a)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errno":  InvalidIPError,
+				"source": e.getName(),
+			}).Warnf(DescribeErrno(InvalidIPError), a)
+			continue
+		}
+		ret = append(ret, cidr)
+	}
+	e.etag = resp.Header.Get("ETag")
+	e.lastModified = resp.Header.Get("Last-Modified")
+	e.cached = ret
+	return ret, nil
+}
+
+// exceptionTextAddresses returns the addresses in a plain text document with one address or
+// CIDR per line, ignoring blank lines and comments starting with #
+func exceptionTextAddresses(buf []byte) (ret []string) {
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return
+}
+
+// exceptionJSONAddresses returns the strings found at each of paths in a JSON document. Arrays
+// found along a path are traversed element by element, and objects missing a key are skipped,
+// so for example the path prefixes.ipv4Prefix matches {"prefixes": [{"ipv4Prefix": "..."}]}.
+func exceptionJSONAddresses(buf []byte, paths [][]string) (ret []string, err error) {
+	var doc interface{}
+	err = json.Unmarshal(buf, &doc)
+	if err != nil {
+		return
+	}
+	var walk func(v interface{}, path []string) error
+	walk = func(v interface{}, path []string) error {
+		switch t := v.(type) {
+		case []interface{}:
+			for _, x := range t {
+				err := walk(x, path)
+				if err != nil {
+					return err
+				}
+			}
+		case map[string]interface{}:
+			if len(path) == 0 {
+				return fmt.Errorf("Expected address but found object")
+			}
+			if x, ok := t[path[0]]; ok {
+				return walk(x, path[1:])
+			}
+		case string:
+			if len(path) != 0 {
+				return fmt.Errorf("Expected object at %s but found string", path[0])
+			}
+			ret = append(ret, t)
+		default:
+			return fmt.Errorf("Unexpected value %v", t)
+		}
+		return nil
+	}
+	for _, p := range paths {
+		err = walk(doc, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// ExceptionSourceStatus describes the health of an exception source
+type ExceptionSourceStatus struct {
+	Name                string    // The exception source name, which is also the creator of its exceptions
+	LastSuccess         time.Time // When exceptions were last updated successfully, zero if never
+	LastError           string    // The error from the last failed update, if any
+	LastErrorTime       time.Time // When the last update failed, zero if never
+	ConsecutiveFailures int       // Number of updates that have failed since the last success
+}
+
+var exceptionStatusLock sync.Mutex
+var exceptionStatus = make(map[string]*ExceptionSourceStatus)
+
+// registerExceptionSource adds an exception source to the status table, so that it is reported
+// before its first update completes
+func registerExceptionSource(e exceptionSource) {
+	exceptionStatusLock.Lock()
+	defer exceptionStatusLock.Unlock()
+	if _, ok := exceptionStatus[e.getName()]; !ok {
+		exceptionStatus[e.getName()] = &ExceptionSourceStatus{Name: e.getName()}
+	}
+}
+
+// recordExceptionUpdate records the result of updating an exception source, and returns the
+// number of consecutive failed updates
+func recordExceptionUpdate(e exceptionSource, err error) int {
+	exceptionStatusLock.Lock()
+	defer exceptionStatusLock.Unlock()
+	st, ok := exceptionStatus[e.getName()]
+	if !ok {
+		st = &ExceptionSourceStatus{Name: e.getName()}
+		exceptionStatus[e.getName()] = st
+	}
+	if err != nil {
+		st.LastError = err.Error()
+		st.LastErrorTime = time.Now()
+		st.ConsecutiveFailures++
+	} else {
+		st.LastSuccess = time.Now()
+		st.ConsecutiveFailures = 0
+	}
+	return st.ConsecutiveFailures
+}
+
+// ExceptionSourceStatuses returns the status of each exception source, ordered by name
+func ExceptionSourceStatuses() (ret []ExceptionSourceStatus) {
+	exceptionStatusLock.Lock()
+	defer exceptionStatusLock.Unlock()
+	for _, st := range exceptionStatus {
+		ret = append(ret, *st)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return
+}
+
+// staleExceptionSources returns the names of exception sources whose last update failed
+func staleExceptionSources() (ret []string) {
+	exceptionStatusLock.Lock()
+	defer exceptionStatusLock.Unlock()
+	for name, st := range exceptionStatus {
+		if st.ConsecutiveFailures > 0 {
+			ret = append(ret, name)
+		}
+	}
+	return
+}
+
+// exceptionRetryDelay returns how long to wait before retrying a source that has failed
+// failures consecutive times. The delay doubles with each failure starting at
+// exceptionRetryMin, and never exceeds the update interval of the source.
+func exceptionRetryDelay(failures int, interval time.Duration) time.Duration {
+	delay := exceptionRetryMin
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		delay = interval
+	}
+	return delay
+}
+
+// updateExceptionSource fetches exceptions from a dynamic source and stores them, recording
+// the result in the source status. It returns the number of consecutive failed updates.
+func updateExceptionSource(e exceptionSource) int {
+	log.Printf("Update exceptions for %s", e.getName())
+	ent, err := e.getExceptions()
+	if p, ok := e.(exceptionPenaltySource); ok && err == nil && p.standingPenalty() > 0 {
+		err = applyStandingPenalty(ent, p.standingPenalty())
+	} else if err == nil {
+		for _, w := range ent {
+			err = db.InsertOrUpdateExceptionEntry(nil, w)
+			if err != nil {
+				break
+			}
+		}
+	}
+	failures := recordExceptionUpdate(e, err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"source":   e.getName(),
+			"failures": failures,
+		}).Warnf("Error updating exceptions: %s", err)
+	}
+	return failures
+}
+
 // InitializeExceptions performs initial housekeeping of the exception table, purging
 // old static data and starting routines that periodically import non-static exception
 // information.
@@ -182,6 +685,11 @@ func InitializeExceptions() error {
 				return err
 			}
 		}
+		registerExceptionSource(v)
+		recordExceptionUpdate(v, nil)
+		if f, ok := v.(*exceptionFile); ok && !exceptionTestHook {
+			go f.watch()
+		}
 	}
 	// Start routine to purge expired exceptions
 	go func() {
@@ -190,11 +698,11 @@ func InitializeExceptions() error {
 		}
 		log.Print("Starting expired exception purge routine")
 		for {
-			err := db.DeleteExpiredExceptions(nil)
+			// Exceptions from sources that are failing to update are kept, rather than
+			// dropping them because the source is unavailable
+			err := db.DeleteExpiredExceptionsExcept(nil, staleExceptionSources())
 			if err != nil {
-				// If something goes wrong deleting expired exceptions treat
-				// this as fatal
-				log.Fatalf("Error removing expired exceptions: %s", err)
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Error removing expired exceptions: %s", err)
 			}
 			time.Sleep(time.Second * 60)
 		}
@@ -205,28 +713,19 @@ func InitializeExceptions() error {
 			continue
 		}
 		ne := exceptionSources[i]
+		registerExceptionSource(ne)
 		go func() {
 			if exceptionTestHook {
 				return
 			}
 			for {
-				// XXX If anything fails here treat this as fatal right now, as we
-				// no longer have up to date exception information. This could probably
-				// be handled better, potentially by noting a critical error and
-				// disabling purge of expired exceptions for this source until we have
-				// valid data again.
-				log.Printf("Update exceptions for %s", ne.getName())
-				ent, err := ne.getExceptions()
-				if err != nil {
-					log.Fatalf("Error updating exception: %s", err)
-				}
-				for _, w := range ent {
-					err = db.InsertOrUpdateExceptionEntry(nil, w)
-					if err != nil {
-						log.Fatalf("Error updating exception: %s", err)
-					}
+				delay := *ne.updateInterval()
+				failures := updateExceptionSource(ne)
+				if failures > 0 {
+					delay = exceptionRetryDelay(failures, delay)
+					log.Printf("Retrying exception update for %s in %s", ne.getName(), delay)
 				}
-				time.Sleep(*ne.updateInterval())
+				time.Sleep(delay)
 			}
 		}()
 	}
@@ -246,9 +745,25 @@ func AddFileException(path string) error {
 	return nil
 }
 
+// AddURLException adds a new exception source that periodically fetches a plain text or JSON
+// document of addresses from a URL, see newExceptionURL for the configuration format
+func AddURLException(config string) error {
+	e, err := newExceptionURL(config)
+	if err != nil {
+		return err
+	}
+	exceptionSources = append(exceptionSources, e)
+	return nil
+}
+
 // AddAWSException adds a new exception source that periodically fetches AWS public address
-// data and adds exceptions for it
-func AddAWSException() error {
-	exceptionSources = append(exceptionSources, &exceptionAWS{})
+// data and adds exceptions for it. config optionally restricts the prefixes used to specific
+// regions and services, for example service:CLOUDFRONT;region:us-east-1
+func AddAWSException(config string) error {
+	e, err := newExceptionAWS(config)
+	if err != nil {
+		return err
+	}
+	exceptionSources = append(exceptionSources, e)
 	return nil
 }
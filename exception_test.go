@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFileExceptionSource(t *testing.T) {
@@ -113,9 +114,16 @@ func TestExceptionApplyOnWriteSingle(t *testing.T) {
 		IP:      "192.168.0.0/24",
 		Creator: "file:/test",
 	}))
-	recorder = httptest.ResponseRecorder{}
-	h.ServeHTTP(&recorder, httptest.NewRequest("PUT", "/192.168.0.1", strings.NewReader(`{"IP": "192.168.0.1", "reputation": 20}`)))
-	assert.Equal(t, http.StatusOK, recorder.Code)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/192.168.0.1", strings.NewReader(`{"IP": "192.168.0.1", "reputation": 20}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var lookup ReputationLookup
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &lookup))
+	assert.Equal(t, LookupExcepted, lookup.Status)
+	if assert.NotNil(t, lookup.Exception) {
+		assert.Equal(t, "192.168.0.0/24", lookup.Exception.IP)
+		assert.Equal(t, "file:/test", lookup.Exception.Creator)
+	}
 	recorder = httptest.ResponseRecorder{}
 	h.ServeHTTP(&recorder, httptest.NewRequest("PUT", "/192.168.1.1", strings.NewReader(`{"IP": "192.168.1.1", "reputation": 20}`)))
 	assert.Equal(t, http.StatusOK, recorder.Code)
@@ -128,6 +136,46 @@ func TestExceptionApplyOnWriteSingle(t *testing.T) {
 	assert.Nil(t, db.Close())
 }
 
+func TestExceptionApplyOnWriteViolationSingle(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	db.EmptyTables()
+
+	SetDB(db)
+	SetViolationPenalties(map[string]uint{"Test:Violation": 90})
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "192.168.0.0/24",
+		Creator: "file:/test",
+	}))
+
+	// Violations for addresses without an exception are applied with no body
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/violations/10.0.0.1", strings.NewReader(`{"Violation": "Test:Violation"}`)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, 0, rec.Body.Len())
+
+	// Violations for excepted addresses succeed with the exception that applied
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/violations/192.168.0.1", strings.NewReader(`{"Violation": "Test:Violation"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var lookup ReputationLookup
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &lookup))
+	assert.Equal(t, LookupExcepted, lookup.Status)
+	assert.Equal(t, uint(100), lookup.Reputation)
+	if assert.NotNil(t, lookup.Exception) {
+		assert.Equal(t, "192.168.0.0/24", lookup.Exception.IP)
+		assert.Equal(t, "file:/test", lookup.Exception.Creator)
+	}
+	_, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, db.EmptyTables())
+	assert.Nil(t, db.Close())
+}
+
 func TestExceptionApplyOnWriteViolationMulti(t *testing.T) {
 	recorder := httptest.ResponseRecorder{}
 	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
@@ -160,9 +208,19 @@ func TestExceptionApplyOnWriteViolationMulti(t *testing.T) {
 		IP:      "10.20.0.0/16",
 		Creator: "file:/test",
 	}))
-	recorder = httptest.ResponseRecorder{}
-	h.ServeHTTP(&recorder, httptest.NewRequest("PUT", "/violations/", strings.NewReader(`[{"ip": "192.168.0.1", "violation": "Test:Violation"}, {"ip": "10.20.20.20", "violation": "Test:Violation2"}]`)))
-	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/violations/", strings.NewReader(`[{"ip": "192.168.0.1", "violation": "Test:Violation"}, {"ip": "10.20.20.20", "violation": "Test:Violation2"}]`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var lookups []ReputationLookup
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &lookups))
+	if assert.Equal(t, 2, len(lookups)) {
+		assert.Equal(t, LookupFound, lookups[0].Status)
+		assert.Equal(t, uint(10), lookups[0].Reputation)
+		assert.Equal(t, LookupExcepted, lookups[1].Status)
+		if assert.NotNil(t, lookups[1].Exception) {
+			assert.Equal(t, "10.20.0.0/16", lookups[1].Exception.IP)
+		}
+	}
 	entry, err = testDB.SelectSmallestMatchingSubnet("192.168.0.1")
 	assert.Nil(t, err)
 	assert.Equal(t, uint(10), entry.Reputation)
@@ -197,9 +255,351 @@ func TestExceptionApplyOnReadSingle(t *testing.T) {
 		IP:      "192.168.0.0/29",
 		Creator: "file:/test",
 	}))
-	recorder = httptest.ResponseRecorder{}
-	h.ServeHTTP(&recorder, httptest.NewRequest("GET", "/192.168.0.1", nil))
-	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/192.168.0.1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	var lookup ReputationLookup
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &lookup))
+	assert.Equal(t, LookupExcepted, lookup.Status)
+	if assert.NotNil(t, lookup.Exception) {
+		assert.Equal(t, "192.168.0.0/29", lookup.Exception.IP)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/10.9.9.9", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &lookup))
+	assert.Equal(t, LookupUnknown, lookup.Status)
+	assert.Nil(t, lookup.Exception)
+
+	assert.Nil(t, db.Close())
+}
+
+func TestAWSExceptionFilter(t *testing.T) {
+	var awsp awsIPRanges
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"prefixes": [
+			{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT"},
+			{"ip_prefix": "18.208.0.0/13", "region": "us-east-1", "service": "EC2"},
+			{"ip_prefix": "52.95.245.0/24", "region": "us-east-1", "service": "CLOUDFRONT"}
+		],
+		"ipv6_prefixes": [
+			{"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT"},
+			{"ipv6_prefix": "2600:1f18::/33", "region": "us-east-1", "service": "EC2"}
+		]
+	}`), &awsp))
+
+	e, err := newExceptionAWS("")
+	assert.Nil(t, err)
+	ret := e.entriesFromRanges(awsp)
+	assert.Equal(t, 5, len(ret))
+	assert.Equal(t, "awsiprange:", ret[0].Creator)
+	assert.Equal(t, "2600:9000::/28", ret[3].IP)
+
+	e, err = newExceptionAWS("service:CLOUDFRONT")
+	assert.Nil(t, err)
+	ret = e.entriesFromRanges(awsp)
+	assert.Equal(t, 3, len(ret))
+	assert.Equal(t, "awsiprange:service:CLOUDFRONT", ret[0].Creator)
+
+	e, err = newExceptionAWS("service:cloudfront;region:us-east-1")
+	assert.Nil(t, err)
+	ret = e.entriesFromRanges(awsp)
+	assert.Equal(t, 1, len(ret))
+	assert.Equal(t, "52.95.245.0/24", ret[0].IP)
+
+	e, err = newExceptionAWS("region:us-east-1;region:GLOBAL;service:EC2")
+	assert.Nil(t, err)
+	ret = e.entriesFromRanges(awsp)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "18.208.0.0/13", ret[0].IP)
+	assert.Equal(t, "2600:1f18::/33", ret[1].IP)
+
+	_, err = newExceptionAWS("service")
+	assert.NotNil(t, err)
+	_, err = newExceptionAWS("zone:us-east-1a")
+	assert.NotNil(t, err)
+}
+
+func TestExceptionAPI(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+
+	SetDB(db)
+	SetAPIKeyCredentials(map[string]string{"oncall": "key1"})
+	assert.Nil(t, SetCredentialScopes(map[string]string{"oncall": "admin"}))
+	defer SetCredentialScopes(nil)
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+	h := HandleWithMiddleware(NewRouter(), []Middleware{RequireAuth()})
+
+	do := func(method string, path string, body string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "APIKey key1")
+		h.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	assert.Equal(t, http.StatusCreated, do("POST", "/exceptions",
+		`{"IP": "198.51.100.0/24", "Reason": "partner NAT", "Expires": "`+expires+`"}`))
+	assert.Equal(t, http.StatusCreated, do("POST", "/exceptions",
+		`{"IP": "2001:db8::1", "Reason": "partner NAT"}`))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/exceptions", `{"IP": "198.51.100.0/24"}`))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/exceptions", `{"IP": "foo", "Reason": "x"}`))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/exceptions",
+		`{"IP": "198.51.100.0/24", "Reason": "x", "Expires": "2001-01-01T00:00:00Z"}`))
+
+	ret, err := db.SelectExceptionsContaining("198.51.100.7")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	assert.Equal(t, "api:oncall", ret[0].Creator)
+	assert.Equal(t, "partner NAT", ret[0].Reason)
+	assert.False(t, ret[0].Expires.IsZero())
+	ret, err = db.SelectExceptionsContaining("2001:db8::1/128")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	assert.True(t, ret[0].Expires.IsZero())
+
+	// Exceptions added through the API survive the initialization purge
+	assert.Nil(t, InitializeExceptions())
+	ret, err = db.SelectExceptionsContaining("198.51.100.7")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+
+	// Only exceptions added through the API can be removed
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "203.0.113.0/24",
+		Creator: "file:/test",
+	}))
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/exceptions/203.0.113.0/24", ""))
+	assert.Equal(t, http.StatusOK, do("DELETE", "/exceptions/198.51.100.0/24", ""))
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/exceptions/198.51.100.0/24", ""))
+	ret, err = db.SelectExceptionsContaining("198.51.100.7")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ret))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestURLExceptionSource(t *testing.T) {
+	var (
+		body     string
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	body = "# egress\n10.1.0.0/16\n\n192.0.2.1 # single host\n2001:db8::/32\n"
+	e, err := newExceptionURL(srv.URL + "/egress.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, urlUpdateInterval, *e.updateInterval())
+	ret, err := e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ret))
+	assert.Equal(t, "url:"+srv.URL+"/egress.txt", ret[0].Creator)
+	assert.Equal(t, "10.1.0.0/16", ret[0].IP)
+	assert.Equal(t, "192.0.2.1/32", ret[1].IP)
+	assert.Equal(t, "2001:db8::/32", ret[2].IP)
+
+	// An unchanged document returns the previous entries
+	body = ""
+	ret, err = e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ret))
+	assert.Equal(t, 2, requests)
+
+	body = `{"prefixes": [{"ipv4Prefix": "8.8.4.0/24"}, {"ipv6Prefix": "2001:4860::/32"}],
+		"extra": {"cidrs": ["198.51.100.0/24"]}}`
+	e, err = newExceptionURL(srv.URL + "/ranges.json;path:prefixes.ipv4Prefix;path:prefixes.ipv6Prefix;" +
+		"path:extra.cidrs;interval:6h")
+	assert.Nil(t, err)
+	assert.Equal(t, 6*time.Hour, *e.updateInterval())
+	ret, err = e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ret))
+	assert.Equal(t, "8.8.4.0/24", ret[0].IP)
+	assert.Equal(t, "2001:4860::/32", ret[1].IP)
+	assert.Equal(t, "198.51.100.0/24", ret[2].IP)
+
+	e, err = newExceptionURL(srv.URL + "/ranges.json;path:prefixes")
+	assert.Nil(t, err)
+	_, err = e.getExceptions()
+	assert.NotNil(t, err)
+
+	// Invalid addresses are skipped like in exception files, and URLs may contain commas
+	body = "10.0.0.0/8\nnot an address\n192.0.2.0/24\n"
+	e, err = newExceptionURL(srv.URL + "/bad.txt?lists=a,b")
+	assert.Nil(t, err)
+	ret, err = e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "10.0.0.0/8", ret[0].IP)
+	assert.Equal(t, "192.0.2.0/24", ret[1].IP)
+
+	body = strings.Repeat("10.0.0.0/8\n", 10)
+	e, err = newExceptionURL(srv.URL + "/big.txt;maxsize:50")
+	assert.Nil(t, err)
+	_, err = e.getExceptions()
+	assert.NotNil(t, err)
+
+	_, err = newExceptionURL("ftp://example.com/list.txt")
+	assert.NotNil(t, err)
+	_, err = newExceptionURL("https://example.com/list.txt;interval:soon")
+	assert.NotNil(t, err)
+	_, err = newExceptionURL("https://example.com/list.txt;jsonpath:a.b")
+	assert.NotNil(t, err)
+}
+
+func TestExceptionRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, exceptionRetryDelay(1, time.Hour))
+	assert.Equal(t, 2*time.Minute, exceptionRetryDelay(2, time.Hour))
+	assert.Equal(t, 32*time.Minute, exceptionRetryDelay(6, time.Hour))
+	assert.Equal(t, time.Hour, exceptionRetryDelay(7, time.Hour))
+	assert.Equal(t, time.Hour, exceptionRetryDelay(1000, time.Hour))
+	assert.Equal(t, 30*time.Second, exceptionRetryDelay(1, 30*time.Second))
+}
+
+func TestExceptionSourceFailure(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+
+	exceptionStatusLock.Lock()
+	exceptionStatus = make(map[string]*ExceptionSourceStatus)
+	exceptionStatusLock.Unlock()
+
+	up := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("10.1.0.0/16\n"))
+	}))
+	defer srv.Close()
+	e, err := newExceptionURL(srv.URL)
+	assert.Nil(t, err)
+	registerExceptionSource(e)
+
+	assert.Equal(t, 0, updateExceptionSource(e))
+	up = false
+	assert.Equal(t, 1, updateExceptionSource(e))
+	assert.Equal(t, 2, updateExceptionSource(e))
+
+	statuses := ExceptionSourceStatuses()
+	assert.Equal(t, 1, len(statuses))
+	assert.Equal(t, e.getName(), statuses[0].Name)
+	assert.Equal(t, 2, statuses[0].ConsecutiveFailures)
+	assert.False(t, statuses[0].LastSuccess.IsZero())
+	assert.Contains(t, statuses[0].LastError, "503")
+	assert.Equal(t, []string{e.getName()}, staleExceptionSources())
+
+	// Expired exceptions from a failing source are kept
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "10.1.0.0/16",
+		Creator: e.getName(),
+		Expires: time.Now().Add(-time.Minute),
+	}))
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "10.2.0.0/16",
+		Creator: "url:other",
+		Expires: time.Now().Add(-time.Minute),
+	}))
+	assert.Nil(t, db.DeleteExpiredExceptionsExcept(nil, staleExceptionSources()))
+	ret, err := db.SelectExceptionsContaining("10.1.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	ret, err = db.SelectExceptionsContaining("10.2.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ret))
+
+	recorder := httptest.NewRecorder()
+	HandleWithMiddleware(NewRouter(), []Middleware{}).ServeHTTP(recorder,
+		httptest.NewRequest("GET", "/__heartbeat__", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var heartbeat struct {
+		Database         bool
+		ExceptionSources []ExceptionSourceStatus
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &heartbeat))
+	assert.True(t, heartbeat.Database)
+	assert.Equal(t, 1, len(heartbeat.ExceptionSources))
+	assert.Equal(t, 2, heartbeat.ExceptionSources[0].ConsecutiveFailures)
+
+	up = true
+	assert.Equal(t, 0, updateExceptionSource(e))
+	assert.Equal(t, 0, len(staleExceptionSources()))
+
+	exceptionStatusLock.Lock()
+	exceptionStatus = make(map[string]*ExceptionSourceStatus)
+	exceptionStatusLock.Unlock()
+	assert.Nil(t, db.Close())
+}
+
+func TestFileExceptionReload(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+
+	f, err := ioutil.TempFile("", "exceptions")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# partner networks\n10.0.0.0/8\n\n192.168.1.1 # office\nnot-an-address\n")
+	f.Close()
+
+	e := &exceptionFile{Path: f.Name()}
+	ret, err := e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "10.0.0.0/8", ret[0].IP)
+	assert.Equal(t, "192.168.1.1/32", ret[1].IP)
+
+	assert.Nil(t, e.reload())
+	ret, err = db.SelectExceptionsContaining("192.168.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+
+	// Removed lines are removed from the exception table and new lines are added
+	assert.Nil(t, ioutil.WriteFile(f.Name(), []byte("10.0.0.0/8\n2001:db8::/32\n"), 0644))
+	assert.Nil(t, e.reload())
+	ret, err = db.SelectExceptionsContaining("192.168.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ret))
+	ret, err = db.SelectExceptionsContaining("2001:db8::1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	ret, err = db.SelectExceptionsContaining("10.1.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+
+	// A failed reload keeps the previous exceptions
+	os.Remove(f.Name())
+	assert.NotNil(t, e.reload())
+	ret, err = db.SelectExceptionsContaining("10.1.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
 
+	exceptionStatusLock.Lock()
+	exceptionStatus = make(map[string]*ExceptionSourceStatus)
+	exceptionStatusLock.Unlock()
 	assert.Nil(t, db.Close())
 }
@@ -0,0 +1,264 @@
+package tigerblood
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushInterval is how many entries are written between flushes of an export response
+const exportFlushInterval = 1000
+
+// ExportReputations calls fn for each reputation entry with a reputation of at most
+// maxReputation, in address order, as rows are read from the database. If family is 4 or 6 only
+// entries of that address family are exported. Addresses covered by an exception are left out:
+// entries inside an exception are skipped, and entries containing exceptions are split into the
+// networks around them, each exported with the entry's reputation.
+func (db DB) ExportReputations(maxReputation uint, family int, fn func(ReputationEntry) error) error {
+	defer dbTiming("export_reputations", time.Now())
+	rows, err := db.Query("SELECT r.ip::text, r.reputation, r.reviewed, "+
+		"array(SELECT e.ip::text FROM exception e WHERE e.ip << r.ip) "+
+		"FROM reputation r WHERE r.reputation <= $1 AND ($2 = 0 OR family(r.ip) = $2) "+
+		"AND NOT EXISTS (SELECT 1 FROM exception e WHERE r.ip <<= e.ip) "+
+		"ORDER BY r.ip", maxReputation, family)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			entry      ReputationEntry
+			exceptions []string
+		)
+		err = rows.Scan(&entry.IP, &entry.Reputation, &entry.Reviewed, pq.Array(&exceptions))
+		if err != nil {
+			return err
+		}
+		nets, err := SubtractCIDRs(entry.IP, exceptions)
+		if err != nil {
+			log.WithFields(log.Fields{"errno": InvalidIPError}).Warnf(DescribeErrno(InvalidIPError),
+				entry.IP)
+			continue
+		}
+		for _, n := range nets {
+			entry.IP = n
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// exportFormat writes exported reputation entries in a blocklist format
+type exportFormat struct {
+	contentType string
+	header      func(w io.Writer, p exportParams) error
+	entry       func(w io.Writer, p exportParams, n int, e ReputationEntry) error
+	footer      func(w io.Writer, p exportParams) error
+}
+
+// exportParams are the options of an export request
+type exportParams struct {
+	name   string // The name of the ipset or AWS WAF IP set
+	scope  string // The AWS WAF IP set scope
+	family int    // 4 or 6, or 0 for both
+}
+
+// exportFormats are the formats supported by the export endpoint, by name
+var exportFormats = map[string]exportFormat{
+	"cidr": {
+		contentType: "text/plain; charset=utf-8",
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			_, err := fmt.Fprintln(w, e.IP)
+			return err
+		},
+	},
+	"csv": {
+		contentType: "text/csv; charset=utf-8",
+		header: func(w io.Writer, p exportParams) error {
+			_, err := fmt.Fprintln(w, "ip,reputation,reviewed")
+			return err
+		},
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			_, err := fmt.Fprintf(w, "%s,%d,%t\n", e.IP, e.Reputation, e.Reviewed)
+			return err
+		},
+	},
+	"jsonl": {
+		contentType: "application/x-ndjson",
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			j, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s\n", j)
+			return err
+		},
+	},
+	"nginx": {
+		contentType: "text/plain; charset=utf-8",
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			_, err := fmt.Fprintf(w, "deny %s;\n", e.IP)
+			return err
+		},
+	},
+	"ipset": {
+		contentType: "text/plain; charset=utf-8",
+		header: func(w io.Writer, p exportParams) error {
+			var err error
+			if p.family != 6 {
+				_, err = fmt.Fprintf(w, "create %s hash:net family inet -exist\n", p.name)
+			}
+			if err == nil && p.family != 4 {
+				_, err = fmt.Fprintf(w, "create %s6 hash:net family inet6 -exist\n", p.name)
+			}
+			return err
+		},
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			name := p.name
+			if strings.Contains(e.IP, ":") {
+				name += "6"
+			}
+			_, err := fmt.Fprintf(w, "add %s %s -exist\n", name, e.IP)
+			return err
+		},
+	},
+	"awswaf": {
+		contentType: "application/json",
+		header: func(w io.Writer, p exportParams) error {
+			version := "IPV4"
+			if p.family == 6 {
+				version = "IPV6"
+			}
+			name, _ := json.Marshal(p.name)
+			scope, _ := json.Marshal(p.scope)
+			_, err := fmt.Fprintf(w, `{"Name":%s,"Scope":%s,"IPAddressVersion":"%s","Addresses":[`,
+				name, scope, version)
+			return err
+		},
+		entry: func(w io.Writer, p exportParams, n int, e ReputationEntry) error {
+			sep := ","
+			if n == 0 {
+				sep = ""
+			}
+			_, err := fmt.Fprintf(w, `%s"%s"`, sep, e.IP)
+			return err
+		},
+		footer: func(w io.Writer, p exportParams) error {
+			_, err := fmt.Fprintln(w, "]}")
+			return err
+		},
+	},
+}
+
+// ExportHandler streams the reputation entries with a reputation of at most the max_reputation
+// query parameter in a blocklist format, leaving out addresses covered by exceptions. The format
+// query parameter selects the format, see exportFormats; it defaults to cidr.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	maxReputation, err := strconv.ParseUint(query.Get("max_reputation"), 10, 32)
+	if err != nil || !IsValidReputation(uint(maxReputation)) {
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError),
+			"max_reputation", query.Get("max_reputation"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = "cidr"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError),
+			"format", formatName)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p := exportParams{name: "tigerblood", scope: "REGIONAL"}
+	switch v := query.Get("family"); v {
+	case "":
+	case "4", "6":
+		p.family, _ = strconv.Atoi(v)
+	default:
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "family", v)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if formatName == "awswaf" && p.family == 0 {
+		// An AWS WAF IP set holds addresses of a single family
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "family", "")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("name"); v != "" {
+		if !IsValidExportName(v) {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "name", v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.name = v
+	}
+	if v := query.Get("scope"); v != "" {
+		if v != "REGIONAL" && v != "CLOUDFRONT" {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "scope", v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.scope = v
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		// Send the headers now, so that the response is streamed rather than buffered by
+		// middleware such as response signing
+		flusher.Flush()
+	}
+	if format.header != nil {
+		err = format.header(bw, p)
+	}
+	var n int
+	if err == nil {
+		err = db.ExportReputations(uint(maxReputation), p.family, func(e ReputationEntry) error {
+			err := format.entry(bw, p, n, e)
+			n++
+			if err == nil && n%exportFlushInterval == 0 {
+				err = bw.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return err
+		})
+	}
+	if err == nil && format.footer != nil {
+		err = format.footer(bw, p)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// The status has already been sent, so the truncated response is the only signal the
+		// client gets
+		requestLog(r, DBError).Warnf("Could not export reputation entries: %s", err)
+		return
+	}
+	log.WithFields(log.Fields{"format": formatName, "entries": n}).Infof("reputations exported")
+}
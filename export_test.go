@@ -0,0 +1,115 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/hawk"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestExportInvalidParameters(t *testing.T) {
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	for _, q := range []string{
+		"",
+		"?max_reputation=101",
+		"?max_reputation=-1",
+		"?max_reputation=50&format=iptables",
+		"?max_reputation=50&family=5",
+		"?max_reputation=50&format=awswaf",
+		"?max_reputation=50&format=ipset&name=bad%3Bname",
+		"?max_reputation=50&format=awswaf&family=4&scope=GLOBAL",
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/export"+q, nil))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, q)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+
+	for _, e := range []ReputationEntry{
+		{IP: "10.0.0.0/24", Reputation: 10},
+		{IP: "192.168.0.1/32", Reputation: 20, Reviewed: true},
+		{IP: "192.168.0.2/32", Reputation: 80},
+		{IP: "2001:db8::/32", Reputation: 0},
+	} {
+		_, err = db.InsertOrUpdateReputationEntry(nil, e)
+		assert.Nil(t, err)
+	}
+	// Exceptions inside an entry split it, exceptions covering an entry remove it
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{IP: "10.0.0.128/25", Creator: "test"}))
+	_, err = db.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "172.16.0.1/32", Reputation: 5})
+	assert.Nil(t, err)
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{IP: "172.16.0.0/16", Creator: "test"}))
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	for _, c := range []struct {
+		query string
+		body  string
+	}{
+		{"?max_reputation=50", "10.0.0.0/25\n192.168.0.1/32\n2001:db8::/32\n"},
+		{"?max_reputation=15&format=cidr&family=4", "10.0.0.0/25\n"},
+		{"?max_reputation=50&format=csv&family=4", "ip,reputation,reviewed\n10.0.0.0/25,10,false\n" +
+			"192.168.0.1/32,20,true\n"},
+		{"?max_reputation=20&format=jsonl&family=4",
+			`{"IP":"10.0.0.0/25","Reputation":10,"Reviewed":false}` + "\n" +
+				`{"IP":"192.168.0.1/32","Reputation":20,"Reviewed":true}` + "\n"},
+		{"?max_reputation=50&format=nginx", "deny 10.0.0.0/25;\ndeny 192.168.0.1/32;\ndeny 2001:db8::/32;\n"},
+		{"?max_reputation=50&format=ipset&name=bad_ips", "create bad_ips hash:net family inet -exist\n" +
+			"create bad_ips6 hash:net family inet6 -exist\nadd bad_ips 10.0.0.0/25 -exist\n" +
+			"add bad_ips 192.168.0.1/32 -exist\nadd bad_ips6 2001:db8::/32 -exist\n"},
+		{"?max_reputation=50&format=awswaf&family=4",
+			`{"Name":"tigerblood","Scope":"REGIONAL","IPAddressVersion":"IPV4",` +
+				`"Addresses":["10.0.0.0/25","192.168.0.1/32"]}` + "\n"},
+		{"?max_reputation=50&format=awswaf&family=6&scope=CLOUDFRONT",
+			`{"Name":"tigerblood","Scope":"CLOUDFRONT","IPAddressVersion":"IPV6",` +
+				`"Addresses":["2001:db8::/32"]}` + "\n"},
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/export"+c.query, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, c.query)
+		assert.Equal(t, c.body, recorder.Body.String(), c.query)
+	}
+
+	// Exports are streamed rather than buffered for signing
+	SetHawkCredentials(map[string]string{"fxa": "foobar"})
+	assert.Nil(t, SetCredentialScopes(map[string]string{"fxa": "read"}))
+	defer SetCredentialScopes(nil)
+	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
+	SetHawkSignResponses(true)
+	defer SetHawkSignResponses(false)
+	server := httptest.NewServer(HandleWithMiddleware(NewRouter(), []Middleware{RequireAuth()}))
+	defer server.Close()
+	client, err := NewClient(server.URL, "fxa", "foobar")
+	assert.Nil(t, err)
+	req, err := http.NewRequest("GET", server.URL+"/export?max_reputation=15&format=cidr&family=4", nil)
+	assert.Nil(t, err)
+	resp, err := client.do(req, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Server-Authorization"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/25\n", string(body))
+	resp.Body.Close()
+
+	client.VerifyResponses = true
+	req, err = http.NewRequest("GET", server.URL+"/export?max_reputation=15&format=cidr&family=4", nil)
+	assert.Nil(t, err)
+	resp, err = client.do(req, nil)
+	assert.Equal(t, hawk.ErrMissingServerAuth, err)
+	assert.Nil(t, resp)
+
+	assert.Nil(t, db.EmptyTables())
+	assert.Nil(t, db.Close())
+}
@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // LoadBalancerHeartbeatHandler returns 200 if the server is up
@@ -17,27 +20,48 @@ func LoadBalancerHeartbeatHandler(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-// HeartbeatHandler pings the DB and returns 200 or 500
+// HeartbeatHandler pings the DB and returns 200 or 500. The response body reports the status
+// of each exception source; a failing exception source does not fail the heartbeat, since
+// existing exceptions are kept until the source recovers.
 func HeartbeatHandler(w http.ResponseWriter, req *http.Request) {
 	if db == nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(req, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		return
 	}
 
+	status := http.StatusOK
 	err := db.Ping()
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	heartbeat := struct {
+		Database         bool
+		ExceptionSources []ExceptionSourceStatus
+	}{
+		Database:         err == nil,
+		ExceptionSources: ExceptionSourceStatuses(),
+	}
+	if heartbeat.ExceptionSources == nil {
+		heartbeat.ExceptionSources = []ExceptionSourceStatus{}
+	}
+	json, err := json.Marshal(heartbeat)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusOK)
+		requestLog(req, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"heartbeat", err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(json)
 }
 
 // VersionHandler returns the version.json file
 func VersionHandler(w http.ResponseWriter, req *http.Request) {
 	dir, err := os.Getwd()
 	if err != nil {
-		log.WithFields(log.Fields{"errno": CWDNotFound}).Warnf(DescribeErrno(CWDNotFound), err)
+		requestLog(req, CWDNotFound).Warnf(DescribeErrno(CWDNotFound), err)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "Could not get CWD")
 		return
@@ -45,7 +69,7 @@ func VersionHandler(w http.ResponseWriter, req *http.Request) {
 	filename := path.Clean(dir + string(os.PathSeparator) + "version.json")
 	f, err := os.Open(filename)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": FileNotFound}).Warnf(DescribeErrno(FileNotFound),
+		requestLog(req, FileNotFound).Warnf(DescribeErrno(FileNotFound),
 			"version.json", err)
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -62,21 +86,22 @@ func VersionHandler(w http.ResponseWriter, req *http.Request) {
 
 // ListViolationsHandler returns a JSON array of known violations for debugging
 func ListViolationsHandler(w http.ResponseWriter, req *http.Request) {
-	if violationPenalties == nil || violationPenaltiesJSON == nil {
-		log.WithFields(log.Fields{"errno": MissingViolations}).Warnf(DescribeErrno(MissingViolations))
+	penalties, penaltiesJSON := currentViolationPenalties()
+	if penalties == nil || penaltiesJSON == nil {
+		requestLog(req, MissingViolations).Warnf("%s", DescribeErrno(MissingViolations))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(violationPenaltiesJSON)
+	w.Write(penaltiesJSON)
 }
 
 // ListExceptionsHandler returns a JSON array of all active exceptions
 func ListExceptionsHandler(w http.ResponseWriter, req *http.Request) {
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(req, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -84,7 +109,7 @@ func ListExceptionsHandler(w http.ResponseWriter, req *http.Request) {
 	entries, err := db.SelectAllExceptions()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": DBError}).Warnf("Could not list exceptions: %s", err)
+		requestLog(req, DBError).Warnf("Could not list exceptions: %s", err)
 		return
 	}
 	if len(entries) == 0 {
@@ -94,7 +119,7 @@ func ListExceptionsHandler(w http.ResponseWriter, req *http.Request) {
 	json, err := json.Marshal(entries)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": JSONMarshalError}).Warnf(DescribeErrno(JSONMarshalError),
+		requestLog(req, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
 			"exceptions", err)
 		return
 	}
@@ -126,6 +151,36 @@ func writeEntryErrorResponse(w http.ResponseWriter, entryIndex int, entry IPViol
 	return
 }
 
+// lookupUnmatched returns the lookup result for ip when it has no reputation entry, reporting
+// the exception that covers it if there is one
+func lookupUnmatched(ip string) (ReputationLookup, error) {
+	l := ReputationLookup{IP: ip, Status: LookupUnknown, Reputation: 100}
+	except, err := db.SelectSmallestMatchingException(ip)
+	if err != nil {
+		return l, err
+	}
+	if except != nil {
+		l.Status = LookupExcepted
+		l.Exception = except
+	}
+	return l, nil
+}
+
+// writeLookupResponse writes v, a ReputationLookup or a slice of them, as the JSON response body
+// with statusCode
+func writeLookupResponse(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"reputation lookup", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(j)
+}
+
 // UpsertReputationByViolationHandler takes a JSON body from the http request
 // and either creates a new reputation entry for the IP address or applies the
 // violation to an existing entry.
@@ -135,19 +190,19 @@ func writeEntryErrorResponse(w http.ResponseWriter, entryIndex int, entry IPViol
 func UpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request) {
 	ip, err := IPAddressFromHTTPPath(r.URL.Path)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": MissingIPError}).Infof(DescribeErrno(MissingIPError))
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !IsValidReputationCIDROrIP(ip) {
 		w.WriteHeader(http.StatusBadRequest)
-		log.WithFields(log.Fields{"errno": InvalidIPError}).Infof(DescribeErrno(InvalidIPError), ip)
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
 		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": BodyReadError}).Warnf(DescribeErrno(BodyReadError), err)
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -157,14 +212,14 @@ func UpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request)
 	var bodyJSON violationBody
 	err = json.Unmarshal(body, &bodyJSON)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": JSONUnmarshalError}).Warnf(DescribeErrno(JSONUnmarshalError),
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError),
 			err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -175,6 +230,7 @@ func UpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request)
 	}
 	penalty, errno := ValidateIPViolationEntryAndGetPenalty(entry)
 	if errno > 0 {
+		SetRequestErrno(r.Context(), errno)
 		switch errno {
 		case MissingIPError:
 			writeEntryErrorResponse(w, 0, entry, http.StatusBadRequest,
@@ -196,25 +252,51 @@ func UpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	if !CanReportViolation(r.Context(), entry.Violation) {
+		SetRequestErrno(r.Context(), InsufficientScopeError)
+		writeEntryErrorResponse(w, 0, entry, http.StatusForbidden,
+			fmt.Sprintf(DescribeErrno(InsufficientScopeError), PrincipalFromContext(r.Context()),
+				ScopeViolate+":"+Scope(entry.Violation)))
+		return
+	}
 
-	ips, penalties := make([]string, 1), make([]uint, 1)
-	ips[0] = ip
-	penalties[0] = penalty
+	penalties := []ReputationPenalty{{
+		IP:         ip,
+		Violation:  entry.Violation,
+		Penalty:    penalty,
+		Recovery:   violationRecovery[entry.Violation],
+		Credential: PrincipalFromContext(r.Context()),
+	}}
 
-	setrep, err := db.InsertOrUpdateReputationPenalties(nil, ips, penalties)
+	setrep, err := db.InsertOrUpdateReputationPenalties(nil, penalties)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"errno": DBError,
-		}).Warnf("Could not update reputation entry by violation: %s", err)
+		requestLog(r, DBError).Warnf("Could not update reputation entry by violation: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if setrep[0] == 100 {
+		// The penalty was either zero or skipped because of an exception
+		l, err := lookupUnmatched(ip)
+		if err != nil {
+			requestLog(r, DBError).Warnf("Could not find exception: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if l.Status == LookupExcepted {
+			requestLog(r, ExceptedIPError).Infof(DescribeErrno(ExceptedIPError), ip, l.Exception.IP,
+				l.Exception.Creator)
+			writeLookupResponse(w, r, http.StatusOK, l)
+			return
+		}
+	}
 	log.WithFields(log.Fields{
-		"ip":         ips[0],
-		"penalty":    penalties[0],
+		"ip":         penalties[0].IP,
+		"penalty":    penalties[0].Penalty,
 		"violation":  entry.Violation,
 		"reputation": setrep[0],
 	}).Infof("violation applied")
+	violationsMetric.WithLabelValues(entry.Violation).Inc()
+	statsdIncr("violation.applied", "violation:"+entry.Violation)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -223,47 +305,43 @@ func UpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request)
 func MultiUpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": BodyReadError}).Warnf(DescribeErrno(BodyReadError), err)
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	var entries []IPViolationEntry
 	err = json.Unmarshal(body, &entries)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": JSONUnmarshalError}).Warnf(DescribeErrno(JSONUnmarshalError),
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError),
 			err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if len(entries) < 1 {
-		log.WithFields(log.Fields{
-			"errno": MissingIPViolationEntryError,
-		}).Warn(DescribeErrno(MissingIPViolationEntryError))
+		requestLog(r, MissingIPViolationEntryError).Warn(DescribeErrno(MissingIPViolationEntryError))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if len(entries) > maxEntries {
-		log.WithFields(log.Fields{
-			"errno": TooManyIPViolationEntriesError,
-		}).Warn(DescribeErrno(TooManyIPViolationEntriesError))
+		requestLog(r, TooManyIPViolationEntriesError).Warn(DescribeErrno(TooManyIPViolationEntriesError))
 		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		return
 	}
 
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	var seenIps = make(map[string]bool)
-	var ips = make([]string, len(entries))
-	var penalties = make([]uint, len(entries))
+	var penalties = make([]ReputationPenalty, len(entries))
 
 	for i, entry := range entries {
 		penalty, errno := ValidateIPViolationEntryAndGetPenalty(entry)
 		if errno > 0 {
+			SetRequestErrno(r.Context(), errno)
 			switch errno {
 			case MissingIPError:
 				writeEntryErrorResponse(w, i, entry, http.StatusBadRequest,
@@ -285,6 +363,13 @@ func MultiUpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Requ
 			}
 			return
 		}
+		if !CanReportViolation(r.Context(), entry.Violation) {
+			SetRequestErrno(r.Context(), InsufficientScopeError)
+			writeEntryErrorResponse(w, i, entry, http.StatusForbidden,
+				fmt.Sprintf(DescribeErrno(InsufficientScopeError), PrincipalFromContext(r.Context()),
+					ScopeViolate+":"+Scope(entry.Violation)))
+			return
+		}
 
 		if _, ok := seenIps[entry.IP]; ok {
 			writeEntryErrorResponse(w, i, entry, http.StatusConflict,
@@ -292,30 +377,240 @@ func MultiUpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 		seenIps[entry.IP] = true
-		ips[i], penalties[i] = entry.IP, penalty
+		penalties[i] = ReputationPenalty{
+			IP:         entry.IP,
+			Violation:  entry.Violation,
+			Penalty:    penalty,
+			Recovery:   violationRecovery[entry.Violation],
+			Credential: PrincipalFromContext(r.Context()),
+		}
 	}
 
-	setrep, err := db.InsertOrUpdateReputationPenalties(nil, ips, penalties)
+	setrep, err := db.InsertOrUpdateReputationPenalties(nil, penalties)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"errno": DBError,
-		}).Warnf("Could not update reputation entry by violation: %s", err)
+		requestLog(r, DBError).Warnf("Could not update reputation entry by violation: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	var (
+		lookups  = make([]ReputationLookup, len(entries))
+		excepted int
+	)
 	for i := range entries {
+		lookups[i] = ReputationLookup{IP: penalties[i].IP, Status: LookupFound, Subnet: penalties[i].IP,
+			Reputation: setrep[i]}
+		if setrep[i] == 100 {
+			// The penalty was either zero or skipped because of an exception
+			l, err := lookupUnmatched(penalties[i].IP)
+			if err != nil {
+				requestLog(r, DBError).Warnf("Could not find exception: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if l.Status == LookupExcepted {
+				requestLog(r, ExceptedIPError).Infof(DescribeErrno(ExceptedIPError), l.IP,
+					l.Exception.IP, l.Exception.Creator)
+				lookups[i] = l
+				excepted++
+				continue
+			}
+		}
 		log.WithFields(log.Fields{
-			"ip":         ips[i],
-			"penalty":    penalties[i],
+			"ip":         penalties[i].IP,
+			"penalty":    penalties[i].Penalty,
 			"violation":  entries[i].Violation,
 			"reputation": setrep[i],
 		}).Infof("violation applied")
+		violationsMetric.WithLabelValues(entries[i].Violation).Inc()
+		statsdIncr("violation.applied", "violation:"+entries[i].Violation)
 	}
 
-	log.Infof("updated %d reputations", len(entries))
+	log.Infof("updated %d reputations", len(entries)-excepted)
+	if excepted > 0 {
+		// Report the result for each entry so the caller can see which exceptions applied
+		writeLookupResponse(w, r, http.StatusOK, lookups)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// defaultViolationHistoryLimit is the number of events returned by ViolationHistoryHandler if
+// no limit is requested
+const defaultViolationHistoryLimit = 100
+
+// ViolationHistoryHandler returns a page of the violation history for the IP address or network
+// on the path, newest first. The limit query parameter sets the page size (at most maxEntries),
+// and the before query parameter takes the Next cursor from a previous page.
+func ViolationHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ip, err := IPAddressFromHTTPPath(r.URL.Path)
+	if err != nil {
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !IsValidReputationCIDROrIP(ip) {
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := parseLimitParameter(r, defaultViolationHistoryLimit)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	before, ok := parseCursorParameter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch one extra event to find out if there is another page
+	events, err := db.SelectViolationEvents(ip, before, limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not get violation history: %s", err)
+		return
+	}
+	history := struct {
+		Violations []ViolationEvent
+		Next       int64
+	}{
+		Violations: []ViolationEvent{},
+	}
+	if len(events) > limit {
+		events = events[:limit]
+		history.Next = events[limit-1].ID
+	}
+	if len(events) > 0 {
+		history.Violations = events
+	}
+	json, err := json.Marshal(history)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"violation history", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// parseLimitParameter returns the limit query parameter, which must be between 1 and maxEntries,
+// or the smaller of defaultLimit and maxEntries if it is not set
+func parseLimitParameter(r *http.Request, defaultLimit int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		if defaultLimit > maxEntries {
+			return maxEntries, true
+		}
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxEntries {
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "limit", v)
+		return 0, false
+	}
+	return limit, true
+}
+
+// parseCursorParameter returns the pagination cursor from the before query parameter, or 0 if
+// it is not set
+func parseCursorParameter(r *http.Request) (int64, bool) {
+	v := r.URL.Query().Get("before")
+	if v == "" {
+		return 0, true
+	}
+	before, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || before < 1 {
+		requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "before", v)
+		return 0, false
+	}
+	return before, true
+}
+
+// defaultAuditLimit is the number of entries returned by AuditHandler if no limit is requested
+const defaultAuditLimit = 100
+
+// AuditHandler returns a page of the audit log of reputation writes, newest first. The ip, actor
+// and since (RFC 3339 timestamp) query parameters filter the entries returned, and limit and
+// before paginate them in the same way as ViolationHistoryHandler.
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	var filter AuditFilter
+	query := r.URL.Query()
+	if v := query.Get("ip"); v != "" {
+		ip, err := IPAddressFromHTTPPath("/" + v)
+		if err != nil || !IsValidReputationCIDROrIP(ip) {
+			requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filter.IP = ip
+	}
+	filter.Actor = query.Get("actor")
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "since", v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filter.Since = since
+	}
+	limit, ok := parseLimitParameter(r, defaultAuditLimit)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter.Before, ok = parseCursorParameter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch one extra entry to find out if there is another page
+	entries, err := db.SelectAuditEntries(filter, limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not get audit entries: %s", err)
+		return
+	}
+	audit := struct {
+		Entries []AuditEntry
+		Next    int64
+	}{
+		Entries: []AuditEntry{},
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		audit.Next = entries[limit-1].ID
+	}
+	if len(entries) > 0 {
+		audit.Entries = entries
+	}
+	json, err := json.Marshal(audit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"audit entries", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
 // UpdateReputationHandler takes a JSON body from the http request and updates that reputation
 // entry in the database. The HTTP requests path has to contain the IP to be updated, in CIDR
 // notation. The body can contain the IP address, or it can be omitted.
@@ -328,26 +623,26 @@ func MultiUpsertReputationByViolationHandler(w http.ResponseWriter, r *http.Requ
 func UpdateReputationHandler(w http.ResponseWriter, r *http.Request) {
 	ip, err := IPAddressFromHTTPPath(r.URL.Path)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": MissingIPError}).Infof(DescribeErrno(MissingIPError))
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !IsValidReputationCIDROrIP(ip) {
 		w.WriteHeader(http.StatusBadRequest)
-		log.WithFields(log.Fields{"errno": InvalidIPError}).Infof(DescribeErrno(InvalidIPError), ip)
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
 		return
 	}
 
 	var entry ReputationEntry
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": BodyReadError}).Warnf(DescribeErrno(BodyReadError), err)
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	err = json.Unmarshal(body, &entry)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": JSONUnmarshalError}).Warnf(DescribeErrno(JSONUnmarshalError),
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError),
 			err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -356,37 +651,54 @@ func UpdateReputationHandler(w http.ResponseWriter, r *http.Request) {
 
 	if !IsValidReputationEntry(entry) {
 		if !IsValidReputationCIDROrIP(entry.IP) {
-			log.WithFields(log.Fields{"errno": InvalidIPError}).Infof(DescribeErrno(InvalidIPError),
+			requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError),
 				entry.IP)
 		}
 		if !IsValidReputation(entry.Reputation) {
-			log.WithFields(log.Fields{
-				"errno": InvalidReputationError,
-			}).Infof(DescribeErrno(InvalidReputationError), entry.Reputation)
+			requestLog(r, InvalidReputationError).Infof(DescribeErrno(InvalidReputationError), entry.Reputation)
 		}
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	retrep, err := db.InsertOrUpdateReputationEntry(nil, entry)
+	var retrep uint
+	err = db.WithAudit(entry.IP, AuditActionSet, PrincipalFromContext(r.Context()),
+		RemoteIPFromRequest(r), func(tx *sql.Tx) (err error) {
+			retrep, err = db.InsertOrUpdateReputationEntry(tx, entry)
+			return err
+		})
 	if _, ok := err.(CheckViolationError); ok {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Reputation is outside of valid range [0-100]"))
 		return
 	} else if err == ErrNoRowsAffected {
-		// This can occur if the address is in the exception list, don't treat this as
-		// an error
-		w.WriteHeader(http.StatusOK)
+		// The address is covered by an exception, so tell the caller which one
+		l, err := lookupUnmatched(entry.IP)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			requestLog(r, DBError).Warnf("Could not find exception: %s", err)
+			return
+		}
+		if l.Status != LookupExcepted {
+			// The exception expired or was removed after the write skipped the address
+			requestLog(r, ExceptedIPError).Infof("IP %s was covered by an exception", entry.IP)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Reputation was not set because of an exception that no longer applies"))
+			return
+		}
+		requestLog(r, ExceptedIPError).Infof(DescribeErrno(ExceptedIPError), entry.IP,
+			l.Exception.IP, l.Exception.Creator)
+		writeLookupResponse(w, r, http.StatusOK, l)
 		return
 	} else if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": DBError}).Warnf("Could not update reputation entry: %s", err)
+		requestLog(r, DBError).Warnf("Could not update reputation entry: %s", err)
 		return
 	}
 	log.WithFields(log.Fields{"ip": entry.IP, "reputation": retrep}).Infof("reputation set")
@@ -397,26 +709,29 @@ func UpdateReputationHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteReputationHandler(w http.ResponseWriter, r *http.Request) {
 	ip, err := IPAddressFromHTTPPath(r.URL.Path)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": MissingIPError}).Infof(DescribeErrno(MissingIPError))
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !IsValidReputationCIDROrIP(ip) {
-		log.WithFields(log.Fields{"errno": InvalidIPError}).Infof(DescribeErrno(InvalidIPError), ip)
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = db.DeleteReputationEntry(nil, ReputationEntry{IP: ip})
+	err = db.WithAudit(ip, AuditActionDelete, PrincipalFromContext(r.Context()),
+		RemoteIPFromRequest(r), func(tx *sql.Tx) error {
+			return db.DeleteReputationEntry(tx, ReputationEntry{IP: ip})
+		})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": DBError}).Warnf("Could not delete reputation entry: %s", err)
+		requestLog(r, DBError).Warnf("Could not delete reputation entry: %s", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -426,39 +741,486 @@ func DeleteReputationHandler(w http.ResponseWriter, r *http.Request) {
 func ReadReputationHandler(w http.ResponseWriter, r *http.Request) {
 	ip, err := IPAddressFromHTTPPath(r.URL.Path)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": MissingIPError}).Infof(DescribeErrno(MissingIPError))
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !IsValidReputationCIDROrIP(ip) {
-		log.WithFields(log.Fields{"errno": InvalidIPError}).Infof(DescribeErrno(InvalidIPError), ip)
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if db == nil {
-		log.WithFields(log.Fields{"errno": MissingDB}).Warnf(DescribeErrno(MissingDB))
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	entry, err := db.SelectSmallestMatchingSubnet(ip)
 	if err == sql.ErrNoRows {
-		w.WriteHeader(http.StatusNotFound)
-		log.Debugf("No entries found for IP %s", ip)
+		// Report whether the address is unknown or covered by an exception in the body, the
+		// status is 404 either way
+		l, err := lookupUnmatched(ip)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			requestLog(r, DBError).Warnf("Could not find exception: %s", err)
+			return
+		}
+		lookupsMetric.WithLabelValues(lookupOutcomes[l.Status]).Inc()
+		log.Debugf("No entries found for IP %s (%s)", ip, l.Status)
+		writeLookupResponse(w, r, http.StatusNotFound, l)
 		return
 	} else if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": DBError}).Warnf("Could not get reputation entry: %s", err)
+		requestLog(r, DBError).Warnf("Could not get reputation entry: %s", err)
 		return
 	}
 	json, err := json.Marshal(entry)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithFields(log.Fields{"errno": JSONMarshalError}).Warnf(DescribeErrno(JSONMarshalError),
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
 			"reputation", err)
 		return
 	}
+	lookupsMetric.WithLabelValues(lookupHit).Inc()
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// LookupReputationsHandler returns the reputation of each IP address or network in a JSON array
+// in the request body, in a single database query. The response is a JSON array of
+// ReputationLookup in the same order, so unknown and excepted addresses are reported rather than
+// omitted.
+func LookupReputationsHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var ips []string
+	err = json.Unmarshal(body, &ips)
+	if err != nil {
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError), err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(ips) < 1 {
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(ips) > maxEntries {
+		requestLog(r, TooManyIPsError).Infof(DescribeErrno(TooManyIPsError), maxEntries)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	normalized := make([]string, len(ips))
+	for i, ip := range ips {
+		normalized[i], err = NormalizeCIDROrIP(ip)
+		if err != nil {
+			requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	lookups, err := db.SelectSmallestMatchingSubnets(normalized)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not look up reputation entries: %s", err)
+		return
+	}
+	for i := range lookups {
+		// Report each address as it was given rather than in its normalized form
+		lookups[i].IP = ips[i]
+		lookupsMetric.WithLabelValues(lookupOutcomes[lookups[i].Status]).Inc()
+	}
+	json, err := json.Marshal(lookups)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"reputation lookups", err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(json)
 }
+
+// ListReputationsHandler returns a page of reputation entries in address order, optionally
+// filtered by reputation range, reviewed flag, containing network and modification time
+func ListReputationsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := ReputationFilter{MaxReputation: 100}
+	query := r.URL.Query()
+	for _, p := range []struct {
+		name string
+		dst  *uint
+	}{
+		{"min_reputation", &filter.MinReputation},
+		{"max_reputation", &filter.MaxReputation},
+	} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || !IsValidReputation(uint(n)) {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), p.name, v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*p.dst = uint(n)
+	}
+	if v := query.Get("reviewed"); v != "" {
+		reviewed, err := strconv.ParseBool(v)
+		if err != nil {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), "reviewed", v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filter.Reviewed = &reviewed
+	}
+	for _, p := range []struct {
+		name string
+		dst  *string
+	}{
+		{"within", &filter.Within},
+		{"after", &filter.After},
+	} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		cidr, err := NormalizeCIDROrIP(v)
+		if err != nil {
+			requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*p.dst = cidr
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"modified_since", &filter.ModifiedSince},
+		{"modified_before", &filter.ModifiedBefore},
+	} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			requestLog(r, InvalidParameterError).Infof(DescribeErrno(InvalidParameterError), p.name, v)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*p.dst = t
+	}
+	limit, ok := parseLimitParameter(r, defaultReputationListLimit)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch one extra entry to find out if there is another page
+	entries, err := db.SelectReputationEntries(filter, limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not list reputation entries: %s", err)
+		return
+	}
+	list := struct {
+		Entries []ReputationListEntry
+		Next    string
+	}{
+		Entries: []ReputationListEntry{},
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		list.Next = entries[limit-1].IP
+	}
+	if len(entries) > 0 {
+		list.Entries = entries
+	}
+	json, err := json.Marshal(list)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"reputation entries", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// ListViolationTypesHandler returns a JSON array of the violation catalog, including disabled
+// violation types
+func ListViolationTypesHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	types, err := db.SelectViolationTypes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not list violation types: %s", err)
+		return
+	}
+	if types == nil {
+		types = []ViolationType{}
+	}
+	json, err := json.Marshal(types)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, JSONMarshalError).Warnf(DescribeErrno(JSONMarshalError),
+			"violation types", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(json)
+}
+
+// CreateViolationTypeHandler adds a violation type to the violation catalog. The violation
+// type is enabled unless the request body sets Enabled to false.
+func CreateViolationTypeHandler(w http.ResponseWriter, r *http.Request) {
+	writeViolationType(w, r, true)
+}
+
+// UpdateViolationTypeHandler updates a violation type in the violation catalog. Fields that
+// are not present in the request body are left unchanged.
+func UpdateViolationTypeHandler(w http.ResponseWriter, r *http.Request) {
+	writeViolationType(w, r, false)
+}
+
+func writeViolationType(w http.ResponseWriter, r *http.Request, create bool) {
+	name := strings.TrimPrefix(r.URL.Path, "/violations/types/")
+	if !IsValidViolationName(name) {
+		requestLog(r, InvalidViolationTypeError).Infof(
+			DescribeErrno(InvalidViolationTypeError), name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	v := ViolationType{Enabled: true}
+	if !create {
+		cur, err := db.SelectViolationType(name)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			requestLog(r, DBError).Warnf("Could not get violation type: %s", err)
+			return
+		}
+		if cur == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		v = *cur
+	}
+	err = json.Unmarshal(body, &v)
+	if err != nil {
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError),
+			err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	v.Name = name
+	if !IsValidViolationPenalty(v.Penalty) {
+		requestLog(r, InvalidParameterError).Infof(
+			DescribeErrno(InvalidParameterError), "Penalty", fmt.Sprint(v.Penalty))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	if create {
+		status = http.StatusCreated
+		err = db.InsertViolationType(nil, v)
+	} else {
+		err = db.UpdateViolationType(nil, v)
+	}
+	if _, ok := err.(DuplicateKeyError); ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if _, ok := err.(CheckViolationError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err == ErrNoRowsAffected {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not write violation type: %s", err)
+		return
+	}
+	reloadViolationTypes()
+	log.WithFields(log.Fields{
+		"violation": v.Name,
+		"penalty":   v.Penalty,
+		"enabled":   v.Enabled,
+	}).Infof("violation type set")
+	w.WriteHeader(status)
+}
+
+// DeleteViolationTypeHandler removes a violation type from the violation catalog
+func DeleteViolationTypeHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/violations/types/")
+	if !IsValidViolationName(name) {
+		requestLog(r, InvalidViolationTypeError).Infof(
+			DescribeErrno(InvalidViolationTypeError), name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err := db.DeleteViolationType(nil, name)
+	if err == ErrNoRowsAffected {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not delete violation type: %s", err)
+		return
+	}
+	reloadViolationTypes()
+	log.WithFields(log.Fields{"violation": name}).Infof("violation type deleted")
+	w.WriteHeader(http.StatusOK)
+}
+
+// reloadViolationTypes applies a catalog change to this replica immediately; other replicas
+// pick it up on their next refresh
+func reloadViolationTypes() {
+	err := LoadViolationTypes()
+	if err != nil {
+		log.WithFields(log.Fields{"errno": DBError}).Warnf("Error reloading violation catalog: %s", err)
+	}
+}
+
+// CreateExceptionHandler adds an exception for an IP address or network. The request body is
+// an ExceptionEntry with the IP, a Reason and optionally an Expires time; the creator is set
+// from the authenticated credential.
+func CreateExceptionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestLog(r, BodyReadError).Warnf(DescribeErrno(BodyReadError), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var entry ExceptionEntry
+	err = json.Unmarshal(body, &entry)
+	if err != nil {
+		requestLog(r, JSONUnmarshalError).Warnf(DescribeErrno(JSONUnmarshalError),
+			err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ip, err := NormalizeCIDROrIP(entry.IP)
+	if err != nil || !IsValidReputationCIDROrIP(ip) {
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), entry.IP)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if entry.Reason == "" {
+		requestLog(r, InvalidParameterError).Infof(
+			DescribeErrno(InvalidParameterError), "Reason", "empty")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !entry.Expires.IsZero() && !entry.Expires.After(time.Now()) {
+		requestLog(r, InvalidParameterError).Infof(
+			DescribeErrno(InvalidParameterError), "Expires", entry.Expires)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	entry.IP = ip
+	entry.Creator = exceptionAPICreatorPrefix + ":" + PrincipalFromContext(r.Context())
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = db.InsertOrUpdateExceptionEntry(nil, entry)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not add exception: %s", err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"ip":      entry.IP,
+		"creator": entry.Creator,
+		"expires": entry.Expires,
+		"reason":  entry.Reason,
+	}).Infof("exception added")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteExceptionHandler removes the exceptions for an IP address or network that were added
+// through the API. Exceptions from configured sources cannot be removed.
+func DeleteExceptionHandler(w http.ResponseWriter, r *http.Request) {
+	ip, err := IPAddressFromHTTPPath(r.URL.Path)
+	if err != nil {
+		requestLog(r, MissingIPError).Infof("%s", DescribeErrno(MissingIPError))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !IsValidReputationCIDROrIP(ip) {
+		requestLog(r, InvalidIPError).Infof(DescribeErrno(InvalidIPError), ip)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if db == nil {
+		requestLog(r, MissingDB).Warnf("%s", DescribeErrno(MissingDB))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = db.DeleteException(nil, ip, exceptionAPICreatorPrefix+":")
+	if err == ErrNoRowsAffected {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		requestLog(r, DBError).Warnf("Could not delete exception: %s", err)
+		return
+	}
+	log.WithFields(log.Fields{"ip": ip}).Infof("exception deleted")
+	w.WriteHeader(http.StatusOK)
+}
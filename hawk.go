@@ -9,7 +9,6 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
-	"time"
 )
 
 // HawkData is hawk config data (credentials is a map of Hawk IDs to passwords)
@@ -26,71 +25,93 @@ func NewHawkData(secrets map[string]string) *HawkData {
 
 // HawkAuth authenticates hawk requests, returns true if successful.
 func HawkAuth(r *http.Request, m *HawkData) bool {
+	_, ok := authenticateHawk(r, m)
+	return ok
+}
+
+// authenticateHawk authenticates hawk requests, returning the request's hawk authorization and
+// true if successful.
+func authenticateHawk(r *http.Request, m *HawkData) (*hawk.Auth, bool) {
 	// Validate the Hawk header format and credentials
-	auth, err := hawk.NewAuthFromRequest(r, m.lookupCredentials, m.lookupNonceNop)
+	// Nonces are checked once the request is otherwise valid, so that only authenticated
+	// requests are recorded in the nonce store
+	auth, err := hawk.NewAuthFromRequest(r, m.lookupCredentials, nil)
 	if err != nil {
 		switch err.(type) {
 		case hawk.AuthFormatError:
-			log.WithFields(log.Fields{"errno": HawkAuthFormatError}).Warn(err)
+			requestLog(r, HawkAuthFormatError).Warn(err)
 		case *hawk.CredentialError:
-			log.WithFields(log.Fields{"errno": HawkCredError}).Warn(err)
+			requestLog(r, HawkCredError).Warn(err)
 		case hawk.AuthError:
 			switch err.(hawk.AuthError) {
 			case hawk.ErrNoAuth:
-				log.WithFields(log.Fields{"errno": HawkErrNoAuth}).Warn(err)
+				requestLog(r, HawkErrNoAuth).Warn(err)
 			case hawk.ErrReplay:
-				log.WithFields(log.Fields{"errno": HawkReplayError}).Warn(err)
+				requestLog(r, HawkReplayError).Warn(err)
 			}
 		default:
-			log.WithFields(log.Fields{"errno": HawkOtherAuthError}).Warnf("other hawk auth error: %s",
+			requestLog(r, HawkOtherAuthError).Warnf("other hawk auth error: %s",
 				err)
 		}
-		return false
+		return nil, false
 	}
 
 	// Validate the header MAC and skew
 	validationError := auth.Valid()
 	if validationError != nil {
-		log.WithFields(log.Fields{"errno": HawkValidationError}).Warnf("hawk validation error: %s",
+		requestLog(r, HawkValidationError).Warnf("hawk validation error: %s",
 			validationError)
-		return false
+		return nil, false
 	}
 
 	// Validate the payload hash of the request Content-Type and body
 	// assuming bodies will fit in memory always validate the body
 	contentType := r.Header.Get("Content-Type")
 	if r.Method != "GET" && r.Method != "DELETE" && contentType == "" {
-		log.WithFields(log.Fields{"errno": HawkMissingContentType}).Warn("hawk: missing content-type")
-		return false
+		requestLog(r, HawkMissingContentType).Warn("hawk: missing content-type")
+		return nil, false
 	}
 
 	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil && contentType != "" {
-		log.WithFields(log.Fields{"errno": HawkMissingContentType}).Warnf("hawk: invalid content-type %s",
+		requestLog(r, HawkMissingContentType).Warnf("hawk: invalid content-type %s",
 			err)
-		return false
+		return nil, false
 	}
 
 	buf, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.WithFields(log.Fields{"errno": HawkReadBodyError}).Warnf("hawk: error reading body %s", err)
-		return false
+		requestLog(r, HawkReadBodyError).Warnf("hawk: error reading body %s", err)
+		return nil, false
 	}
 
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
 	hash := auth.PayloadHash(mediaType)
 	io.Copy(hash, ioutil.NopCloser(bytes.NewBuffer(buf)))
 	if !auth.ValidHash(hash) {
-		log.WithFields(log.Fields{"errno": HawkInvalidBodyHash}).Warnf("hawk: invalid payload hash")
-		return false
+		requestLog(r, HawkInvalidBodyHash).Warnf("hawk: invalid payload hash")
+		return nil, false
 	}
 
-	log.WithFields(log.Fields{"id": auth.Credentials.ID}).Infof("hawk: accepted request")
-	return true
-}
+	if hawkNonces != nil {
+		ok, err := hawkNonces.Add(auth.Credentials.ID, auth.Nonce, auth.Timestamp)
+		if err == ErrNonceStoreFull {
+			// The store logs this itself at a limited rate
+			SetRequestErrno(r.Context(), HawkNonceStoreFull)
+			return nil, false
+		}
+		if err != nil {
+			requestLog(r, DBError).Warnf("hawk: error recording nonce %s", err)
+			return nil, false
+		}
+		if !ok {
+			requestLog(r, HawkReplayError).Warn(hawk.ErrReplay)
+			return nil, false
+		}
+	}
 
-func (h *HawkData) lookupNonceNop(nonce string, t time.Time, credentials *hawk.Credentials) bool {
-	return true
+	log.WithFields(log.Fields{"id": auth.Credentials.ID}).Infof("hawk: accepted request")
+	return auth, true
 }
 
 func (h *HawkData) lookupCredentials(creds *hawk.Credentials) error {
@@ -105,3 +126,88 @@ func (h *HawkData) lookupCredentials(creds *hawk.Credentials) error {
 		Credentials: creds,
 	}
 }
+
+// hawkSignResponses is true if responses to Hawk authenticated requests are signed
+var hawkSignResponses bool
+
+// SetHawkSignResponses sets whether responses to Hawk authenticated requests are signed with a
+// Server-Authorization header
+func SetHawkSignResponses(sign bool) {
+	hawkSignResponses = sign
+}
+
+// hawkResponseWriter buffers a response so that it can be signed with a Hawk
+// Server-Authorization header covering the payload hash, which has to be sent before the body.
+// Streaming handlers that flush, such as exports, are not buffered and their response is not
+// signed.
+type hawkResponseWriter struct {
+	http.ResponseWriter
+	auth      *hawk.Auth
+	status    int
+	body      bytes.Buffer
+	streaming bool // The response is sent as it is written, without a signature
+}
+
+func (w *hawkResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *hawkResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// Flush implements http.Flusher. The payload hash cannot be computed before the whole body is
+// written, so the first flush sends the response so far without a Server-Authorization header
+// and later writes go straight to the client.
+func (w *hawkResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		log.WithFields(log.Fields{"id": w.auth.Credentials.ID}).Infof("hawk: not signing streamed response")
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.body.Bytes())
+		if err != nil {
+			log.Warnf("hawk: error writing streamed response %s", err)
+		}
+		w.body.Reset()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sign sets the Server-Authorization header for the buffered response and writes it. Nothing is
+// done if the response was streamed.
+func (w *hawkResponseWriter) sign() {
+	if w.streaming {
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	body := w.body.Bytes()
+	if w.Header().Get("Content-Type") == "" && len(body) > 0 {
+		// Set the type net/http would sniff, as the payload hash covers it
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	hash := w.auth.PayloadHash(mediaType)
+	hash.Write(body)
+	w.auth.SetHash(hash)
+	w.Header().Set("Server-Authorization", w.auth.ResponseHeader(""))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(body)
+	if err != nil {
+		log.Warnf("hawk: error writing signed response %s", err)
+	}
+}
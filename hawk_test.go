@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.mozilla.org/hawk"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -25,6 +26,7 @@ func TestMissingAuthorization(t *testing.T) {
 	credentials := make(map[string]string)
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -38,6 +40,7 @@ func TestInvalidAuthorization(t *testing.T) {
 	credentials := make(map[string]string)
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -62,6 +65,7 @@ func TestInvalidPayload(t *testing.T) {
 	credentials := map[string]string{"fxa": "foobar"}
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -87,11 +91,141 @@ func TestValidPayload(t *testing.T) {
 	credentials := map[string]string{"fxa": "foobar"}
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusOK, recorder.Code)
 }
 
+func TestReplay(t *testing.T) {
+	SetHawkNonceStore(NewLRUNonceStore(10))
+	defer SetHawkNonceStore(NewLRUNonceStore(DefaultNonceCacheSize))
+	SetHawkCredentials(map[string]string{"fxa": "foobar"})
+	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
+	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
+
+	req := httptest.NewRequest("GET", "http://foo.bar/", nil)
+	auth := hawk.NewRequestAuth(req,
+		&hawk.Credentials{
+			ID:   "fxa",
+			Key:  "foobar",
+			Hash: sha256.New,
+		},
+		0,
+	)
+	auth.SetHash(auth.PayloadHash(""))
+	header := auth.RequestHeader()
+	req.Header.Set("Authorization", header)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// The same header is rejected the second time
+	req = httptest.NewRequest("GET", "http://foo.bar/", nil)
+	req.Header.Set("Authorization", header)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// New requests are rejected while the store is full of nonces that could be replayed
+	SetHawkNonceStore(NewLRUNonceStore(1))
+	for _, code := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req = httptest.NewRequest("GET", "http://foo.bar/", nil)
+		auth = hawk.NewRequestAuth(req, &hawk.Credentials{ID: "fxa", Key: "foobar", Hash: sha256.New}, 0)
+		auth.SetHash(auth.PayloadHash(""))
+		req.Header.Set("Authorization", auth.RequestHeader())
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, code, recorder.Code)
+	}
+}
+
+func TestSignedResponse(t *testing.T) {
+	SetHawkCredentials(map[string]string{"fxa": "foobar"})
+	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
+	SetHawkSignResponses(true)
+	defer SetHawkSignResponses(false)
+	exceptions := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"IP":"10.0.0.0/8"}]`))
+	})
+	server := httptest.NewServer(HandleWithMiddleware(exceptions, []Middleware{RequireAuth()}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "fxa", "foobar")
+	assert.Nil(t, err)
+	client.VerifyResponses = true
+	resp, err := client.Exceptions()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, resp.Header.Get("Server-Authorization"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"IP":"10.0.0.0/8"}]`, string(body))
+
+	// A response changed by a proxy is rejected
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		HandleWithMiddleware(exceptions, []Middleware{RequireAuth()}).ServeHTTP(rec, r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Server-Authorization", rec.Header().Get("Server-Authorization"))
+		w.Write([]byte(`[]`))
+	}))
+	defer proxy.Close()
+	client.URL = proxy.URL
+	resp, err = client.Exceptions()
+	assert.Equal(t, ClientInvalidPayloadHashError, err)
+	assert.Nil(t, resp)
+
+	// An unsigned response is rejected
+	SetHawkSignResponses(false)
+	client.URL = server.URL
+	resp, err = client.Exceptions()
+	assert.Equal(t, hawk.ErrMissingServerAuth, err)
+	assert.Nil(t, resp)
+
+	client.VerifyResponses = false
+	resp, err = client.Exceptions()
+	assert.Nil(t, err)
+}
+
+func TestStreamedResponseNotSigned(t *testing.T) {
+	SetHawkCredentials(map[string]string{"fxa": "foobar"})
+	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
+	SetHawkSignResponses(true)
+	defer SetHawkSignResponses(false)
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("10.0.0.0/8\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("192.168.0.0/16\n"))
+	})
+	server := httptest.NewServer(HandleWithMiddleware(stream, []Middleware{RequireAuth()}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "fxa", "foobar")
+	assert.Nil(t, err)
+	req, err := http.NewRequest("GET", server.URL+"/", nil)
+	assert.Nil(t, err)
+	resp, err := client.do(req, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Server-Authorization"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/8\n192.168.0.0/16\n", string(body))
+
+	// Clients that require signed responses reject streamed responses
+	client.VerifyResponses = true
+	req, err = http.NewRequest("GET", server.URL+"/", nil)
+	assert.Nil(t, err)
+	resp, err = client.do(req, nil)
+	assert.Equal(t, hawk.ErrMissingServerAuth, err)
+	assert.Nil(t, resp)
+}
+
 func TestValidPayloadNoContentType(t *testing.T) {
 	// use a POST to hit log the missing content type warning
 	req, err := http.NewRequest("POST", "http://foo.bar/", bytes.NewReader([]byte("foo")))
@@ -113,6 +247,7 @@ func TestValidPayloadNoContentType(t *testing.T) {
 	credentials := map[string]string{"fxa": "foobar"}
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -126,6 +261,8 @@ func TestExpiration(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	credentials := map[string]string{"fxa": "foobar"}
 	SetHawkCredentials(credentials)
+	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -149,6 +286,7 @@ func TestLoadbalancerEndpointsUnauthed(t *testing.T) {
 		credentials := map[string]string{"fxa": "foobar"}
 		SetHawkCredentials(credentials)
 		SetAuthMask(AuthEnableHawk)
+		defer SetAuthMask(0)
 		handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 		handler.ServeHTTP(recorder, req)
 		assert.Equal(t, http.StatusOK, recorder.Code)
@@ -174,6 +312,7 @@ func TestMissingCredentialsReturns401(t *testing.T) {
 	credentials := map[string]string{"notFxa": "foobar"}
 	SetHawkCredentials(credentials)
 	SetAuthMask(AuthEnableHawk)
+	defer SetAuthMask(0)
 	handler := HandleWithMiddleware(EchoHandler, []Middleware{RequireAuth()})
 	handler.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
@@ -3,10 +3,11 @@ package tigerblood
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 )
 
-// IPAddressFromHTTPPath takes a HTTP path and returns an IPv4 IP if it's found, or an error if none is found.
+// IPAddressFromHTTPPath takes a HTTP path and returns an IPv4 or IPv6 CIDR if it's found, or an error if none is found.
 func IPAddressFromHTTPPath(path string) (string, error) {
 	if path == "" {
 		return "", fmt.Errorf("Invalid path")
@@ -36,3 +37,89 @@ func IPAddressFromHTTPPath(path string) (string, error) {
 	}
 	return n.String(), nil
 }
+
+// RemoteIPFromRequest returns the IP address of the client that sent the request
+func RemoteIPFromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NormalizeCIDROrIP takes an IPv4 or IPv6 address or CIDR and returns it as a CIDR in canonical
+// form, using a single address mask for addresses. It returns an error if s is neither.
+func NormalizeCIDROrIP(s string) (string, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", err
+		}
+		return n.String(), nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("Invalid IP address %q", s)
+	}
+	n := &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	if ip.To4() != nil {
+		n = &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	return n.String(), nil
+}
+
+// SubtractCIDRs returns the networks covering the addresses in cidr that are not in any of
+// excluded, in address order. All arguments may be IP addresses or CIDRs.
+func SubtractCIDRs(cidr string, excluded []string) ([]string, error) {
+	n, err := parseCIDROrIP(cidr)
+	if err != nil {
+		return nil, err
+	}
+	nets := []*net.IPNet{n}
+	for _, e := range excluded {
+		en, err := parseCIDROrIP(e)
+		if err != nil {
+			return nil, err
+		}
+		var rest []*net.IPNet
+		for _, n := range nets {
+			rest = append(rest, subtractNet(n, en)...)
+		}
+		nets = rest
+	}
+	ret := make([]string, len(nets))
+	for i, n := range nets {
+		ret[i] = n.String()
+	}
+	return ret, nil
+}
+
+// parseCIDROrIP parses an IP address or CIDR, see NormalizeCIDROrIP
+func parseCIDROrIP(s string) (*net.IPNet, error) {
+	c, err := NormalizeCIDROrIP(s)
+	if err != nil {
+		return nil, err
+	}
+	_, n, err := net.ParseCIDR(c)
+	return n, err
+}
+
+// subtractNet returns the networks covering the addresses in n that are not in e, by splitting
+// n in half until each half is either entirely inside or entirely outside e
+func subtractNet(n *net.IPNet, e *net.IPNet) []*net.IPNet {
+	if len(n.IP) != len(e.IP) || (!n.Contains(e.IP) && !e.Contains(n.IP)) {
+		return []*net.IPNet{n}
+	}
+	ones, bits := n.Mask.Size()
+	eones, _ := e.Mask.Size()
+	if eones <= ones {
+		// e contains n
+		return nil
+	}
+	mask := net.CIDRMask(ones+1, bits)
+	hi := make(net.IP, len(n.IP))
+	copy(hi, n.IP)
+	hi[ones/8] |= 0x80 >> uint(ones%8)
+	return append(subtractNet(&net.IPNet{IP: n.IP, Mask: mask}, e),
+		subtractNet(&net.IPNet{IP: hi, Mask: mask}, e)...)
+}
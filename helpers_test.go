@@ -73,3 +73,55 @@ func TestIPAddressFromHTTPPath(t *testing.T) {
 		assert.Equal(t, c.ip, ip)
 	}
 }
+
+func TestNormalizeCIDROrIP(t *testing.T) {
+	var cases = []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{"192.168.0.1", "192.168.0.1/32", false},
+		{"192.168.0.1/24", "192.168.0.0/24", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"foo/192.168.0.1", "", true},
+		{"192.168.0.1/33", "", true},
+		{"", "", true},
+	}
+	for _, c := range cases {
+		out, err := NormalizeCIDROrIP(c.in)
+		if c.err {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, c.out, out)
+	}
+}
+
+func TestSubtractCIDRs(t *testing.T) {
+	ret, err := SubtractCIDRs("10.0.0.0/24", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.0/24"}, ret)
+
+	ret, err = SubtractCIDRs("10.0.0.0/24", []string{"10.0.0.128/25"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.0/25"}, ret)
+
+	ret, err = SubtractCIDRs("10.0.0.0/24", []string{"10.0.0.4/30", "10.0.0.200"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.0/30", "10.0.0.8/29", "10.0.0.16/28", "10.0.0.32/27",
+		"10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/29", "10.0.0.201/32", "10.0.0.202/31",
+		"10.0.0.204/30", "10.0.0.208/28", "10.0.0.224/27"}, ret)
+
+	ret, err = SubtractCIDRs("10.0.0.1", []string{"10.0.0.0/8"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, ret)
+
+	ret, err = SubtractCIDRs("2001:db8::/32", []string{"2001:db8:8000::/33", "10.0.0.0/8"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2001:db8::/33"}, ret)
+
+	_, err = SubtractCIDRs("garbage", nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,30 @@
+package tigerblood
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// violationHistoryPurgeInterval is how often violation history older than the retention
+// period is removed
+const violationHistoryPurgeInterval = time.Hour
+
+// StartViolationHistoryPurge starts a routine that periodically removes violation history
+// older than retention. Purging is idempotent, so it can run on every replica.
+func StartViolationHistoryPurge(retention time.Duration) {
+	if retention <= 0 {
+		log.Fatalf("Invalid violation history retention: %s", retention)
+	}
+	go func() {
+		log.Printf("Starting violation history purge routine (retention %s)", retention)
+		for {
+			n, err := db.DeleteViolationEventsBefore(nil, time.Now().Add(-retention))
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Error removing violation history: %s", err)
+			} else if n > 0 {
+				log.Printf("Removed %d violation history events", n)
+			}
+			time.Sleep(violationHistoryPurgeInterval)
+		}
+	}()
+}
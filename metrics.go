@@ -0,0 +1,205 @@
+package tigerblood
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lookup outcomes recorded by the lookups metric
+const (
+	lookupHit      = "hit"      // A reputation entry was found
+	lookupMiss     = "miss"     // No reputation entry was found
+	lookupExcepted = "excepted" // The address is covered by an exception
+)
+
+// lookupOutcomes maps reputation lookup statuses to lookup metric outcomes
+var lookupOutcomes = map[string]string{
+	LookupFound:    lookupHit,
+	LookupUnknown:  lookupMiss,
+	LookupExcepted: lookupExcepted,
+}
+
+var (
+	requestsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tigerblood",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route name, method and response status code.",
+	}, []string{"route", "method", "code"})
+	requestDurationMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tigerblood",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route name and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	violationsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tigerblood",
+		Name:      "violations_total",
+		Help:      "Violations applied by violation type.",
+	}, []string{"violation"})
+	lookupsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tigerblood",
+		Name:      "lookups_total",
+		Help:      "Reputation lookups by outcome (hit, miss or excepted).",
+	}, []string{"outcome"})
+)
+
+// metricsRegistry holds the metrics exposed on /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(
+		requestsMetric,
+		requestDurationMetric,
+		violationsMetric,
+		lookupsMetric,
+		dbCollector{},
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// MetricsHandler serves metrics in the Prometheus exposition format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// statusRecorder is an http.ResponseWriter that records the response status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the underlying http.ResponseWriter, for streaming responses
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrumentRoute wraps the handler for a route to record request counts and latency under
+// the route name, both as Prometheus metrics and to statsd
+func instrumentRoute(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		setRequestRoute(r.Context(), name)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		requestDurationMetric.WithLabelValues(name, r.Method).Observe(time.Since(start).Seconds())
+		requestsMetric.WithLabelValues(name, r.Method, strconv.Itoa(rec.status)).Inc()
+		statsdRequest(name, r.Method, rec.status, start)
+	})
+}
+
+// exceptionCounts holds the number of active exceptions by source, counted by
+// StartExceptionCounts rather than on every scrape so that scraping does not query the database
+var (
+	exceptionCountsLock sync.RWMutex
+	exceptionCounts     map[string]int64
+)
+
+// creatorSource returns the source type of an exception creator, e.g. file for
+// file:/etc/exceptions, so that metrics do not expose paths, URLs or credential IDs
+func creatorSource(creator string) string {
+	return strings.SplitN(creator, ":", 2)[0]
+}
+
+// countExceptions counts active exceptions by source for the exceptions metric
+func countExceptions() error {
+	counts, err := db.CountExceptionsByCreator()
+	if err != nil {
+		return err
+	}
+	sources := make(map[string]int64)
+	for creator, n := range counts {
+		sources[creatorSource(creator)] += n
+	}
+	exceptionCountsLock.Lock()
+	exceptionCounts = sources
+	exceptionCountsLock.Unlock()
+	return nil
+}
+
+// StartExceptionCounts starts a routine that counts active exceptions by source every interval
+// for the tigerblood_exceptions metric
+func StartExceptionCounts(interval time.Duration) {
+	if interval <= 0 {
+		log.Fatalf("Invalid exception count interval: %s", interval)
+	}
+	go func() {
+		log.Printf("Starting exception count routine (every %s)", interval)
+		for {
+			err := countExceptions()
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Could not count exceptions: %s", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// dbCollector collects connection pool statistics from the database and the exception counts
+// last counted by StartExceptionCounts when metrics are scraped
+type dbCollector struct{}
+
+var (
+	dbOpenConnectionsDesc = prometheus.NewDesc("tigerblood_db_open_connections",
+		"Established database connections, both in use and idle.", nil, nil)
+	dbInUseConnectionsDesc = prometheus.NewDesc("tigerblood_db_in_use_connections",
+		"Database connections currently in use.", nil, nil)
+	dbIdleConnectionsDesc = prometheus.NewDesc("tigerblood_db_idle_connections",
+		"Idle database connections.", nil, nil)
+	dbMaxOpenConnectionsDesc = prometheus.NewDesc("tigerblood_db_max_open_connections",
+		"Maximum number of open database connections.", nil, nil)
+	dbWaitCountDesc = prometheus.NewDesc("tigerblood_db_wait_count_total",
+		"Total number of times a request waited for a database connection.", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("tigerblood_db_wait_duration_seconds_total",
+		"Total time spent waiting for a database connection.", nil, nil)
+	exceptionsDesc = prometheus.NewDesc("tigerblood_exceptions",
+		"Active exceptions by source type.", []string{"source"}, nil)
+)
+
+func (c dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbOpenConnectionsDesc
+	ch <- dbInUseConnectionsDesc
+	ch <- dbIdleConnectionsDesc
+	ch <- dbMaxOpenConnectionsDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+	ch <- exceptionsDesc
+}
+
+func (c dbCollector) Collect(ch chan<- prometheus.Metric) {
+	exceptionCountsLock.RLock()
+	for source, n := range exceptionCounts {
+		ch <- prometheus.MustNewConstMetric(exceptionsDesc, prometheus.GaugeValue, float64(n), source)
+	}
+	exceptionCountsLock.RUnlock()
+
+	if db == nil {
+		return
+	}
+	stats := db.Stats()
+	ch <- prometheus.MustNewConstMetric(dbOpenConnectionsDesc, prometheus.GaugeValue,
+		float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUseConnectionsDesc, prometheus.GaugeValue,
+		float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdleConnectionsDesc, prometheus.GaugeValue,
+		float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenConnectionsDesc, prometheus.GaugeValue,
+		float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue,
+		float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue,
+		stats.WaitDuration.Seconds())
+}
@@ -0,0 +1,57 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	SetDB(nil)
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+
+	req := httptest.NewRequest("GET", "/__lbheartbeat__", nil)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	res := recorder.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body),
+		`tigerblood_http_requests_total{code="200",method="GET",route="LoadBalancerHeartbeat"}`)
+	assert.Contains(t, string(body), "tigerblood_http_request_duration_seconds_bucket")
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestCreatorSource(t *testing.T) {
+	assert.Equal(t, "api", creatorSource("api:oncall"))
+	assert.Equal(t, "url", creatorSource("url:https://example.com/exceptions?a=1"))
+	assert.Equal(t, "file", creatorSource("file:/etc/tigerblood/exceptions"))
+	assert.Equal(t, "test", creatorSource("test"))
+}
+
+func TestMetricsExceptionCounts(t *testing.T) {
+	SetDB(nil)
+	exceptionCountsLock.Lock()
+	exceptionCounts = map[string]int64{"api": 2, "file": 3}
+	exceptionCountsLock.Unlock()
+	defer func() {
+		exceptionCountsLock.Lock()
+		exceptionCounts = nil
+		exceptionCountsLock.Unlock()
+	}()
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `tigerblood_exceptions{source="api"} 2`)
+	assert.Contains(t, recorder.Body.String(), `tigerblood_exceptions{source="file"} 3`)
+}
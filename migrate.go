@@ -0,0 +1,384 @@
+package tigerblood
+
+import (
+	"database/sql"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// migrationLockID is the postgres advisory lock key held while migrations are applied, so
+// that several instances starting at once apply each migration exactly once
+const migrationLockID = 0x7469676572 // "tiger"
+
+// migration is a single numbered schema change. Up applies the change and Down reverts it.
+type migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// MigrationStatus describes a known migration and whether it has been applied to the database
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     time.Time // Zero if the migration has not been applied
+}
+
+// migrations is the ordered list of schema migrations. Versions must be consecutive starting
+// at 1, and migrations must never be modified or reordered once released; add a new migration
+// instead.
+//
+// The first migrations use IF NOT EXISTS and column checks so that databases created before
+// schema versioning was introduced are adopted without error.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create reputation table",
+		Up: `
+CREATE TABLE IF NOT EXISTS reputation (
+ip ip4r PRIMARY KEY NOT NULL,
+reputation int NOT NULL CHECK (reputation >= 0 AND reputation <= 100)
+);
+CREATE INDEX IF NOT EXISTS reputation_ip_idx ON reputation USING gist (ip);
+`,
+		Down: `
+DROP TABLE reputation;
+`,
+	},
+	{
+		Version:     2,
+		Description: "add reviewed flag to reputation",
+		Up: `
+DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'reputation'
+			AND column_name = 'reviewed') THEN
+			ALTER TABLE reputation ADD COLUMN reviewed boolean DEFAULT false;
+		END IF;
+	END;
+$$;
+
+CREATE OR REPLACE FUNCTION reviewed_reset() RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.reviewed = false;
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS check_reviewed ON reputation;
+CREATE TRIGGER check_reviewed BEFORE UPDATE ON reputation
+	FOR EACH ROW WHEN (NEW.reputation = 100)
+	EXECUTE PROCEDURE reviewed_reset();
+`,
+		Down: `
+DROP TRIGGER check_reviewed ON reputation;
+DROP FUNCTION reviewed_reset();
+ALTER TABLE reputation DROP COLUMN reviewed;
+`,
+	},
+	{
+		Version:     3,
+		Description: "create exception table",
+		Up: `
+CREATE TABLE IF NOT EXISTS exception (
+ip ip4r NOT NULL,
+modified timestamp with time zone NOT NULL,
+expires timestamp with time zone,
+creator text NOT NULL,
+UNIQUE(ip, creator)
+);
+CREATE INDEX IF NOT EXISTS exception_ip_idx ON exception USING gist (ip);
+`,
+		Down: `
+DROP TABLE exception;
+`,
+	},
+	{
+		Version:     4,
+		Description: "store reputation and exception addresses as iprange for IPv6 support",
+		Up: `
+DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'reputation'
+			AND column_name = 'ip' AND udt_name = 'ip4r') THEN
+			ALTER TABLE reputation ALTER COLUMN ip TYPE iprange USING ip::iprange;
+		END IF;
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'exception'
+			AND column_name = 'ip' AND udt_name = 'ip4r') THEN
+			ALTER TABLE exception ALTER COLUMN ip TYPE iprange USING ip::iprange;
+		END IF;
+	END;
+$$;
+`,
+		// Fails if any IPv6 entries are present
+		Down: `
+ALTER TABLE reputation ALTER COLUMN ip TYPE ip4r USING ip::ip4r;
+ALTER TABLE exception ALTER COLUMN ip TYPE ip4r USING ip::ip4r;
+`,
+	},
+	{
+		Version:     5,
+		Description: "create reputation_penalty table for per-violation recovery",
+		Up: `
+CREATE TABLE reputation_penalty (
+ip iprange NOT NULL REFERENCES reputation (ip) ON DELETE CASCADE ON UPDATE CASCADE,
+violation text NOT NULL,
+penalty int NOT NULL CHECK (penalty >= 0 AND penalty <= 100),
+recovered int NOT NULL DEFAULT 0,
+recovery interval,
+applied timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX reputation_penalty_ip_idx ON reputation_penalty (ip);
+`,
+		Down: `
+DROP TABLE reputation_penalty;
+`,
+	},
+	{
+		Version:     6,
+		Description: "create violation_event history table",
+		Up: `
+CREATE TABLE violation_event (
+id bigserial PRIMARY KEY,
+ip iprange NOT NULL,
+violation text NOT NULL,
+penalty int NOT NULL,
+reputation int NOT NULL,
+credential text,
+created timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX violation_event_ip_idx ON violation_event USING gist (ip);
+CREATE INDEX violation_event_created_idx ON violation_event (created);
+`,
+		Down: `
+DROP TABLE violation_event;
+`,
+	},
+	{
+		Version:     7,
+		Description: "create audit table",
+		Up: `
+CREATE TABLE audit (
+id bigserial PRIMARY KEY,
+ip iprange NOT NULL,
+action text NOT NULL,
+old_reputation int,
+new_reputation int,
+old_reviewed boolean,
+new_reviewed boolean,
+actor text,
+source_ip text NOT NULL,
+created timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX audit_ip_idx ON audit USING gist (ip);
+CREATE INDEX audit_actor_idx ON audit (actor);
+CREATE INDEX audit_created_idx ON audit (created);
+`,
+		Down: `
+DROP TABLE audit;
+`,
+	},
+	{
+		Version:     8,
+		Description: "create violation type catalog table",
+		Up: `
+CREATE TABLE violation (
+name text PRIMARY KEY NOT NULL,
+penalty int NOT NULL CHECK (penalty >= 0 AND penalty <= 100),
+description text NOT NULL DEFAULT '',
+enabled boolean NOT NULL DEFAULT true,
+modified timestamp with time zone NOT NULL DEFAULT now()
+);
+`,
+		Down: `
+DROP TABLE violation;
+`,
+	},
+	{
+		Version:     9,
+		Description: "add reason to exception",
+		Up: `
+ALTER TABLE exception ADD COLUMN reason text NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE exception DROP COLUMN reason;
+`,
+	},
+	{
+		Version:     10,
+		Description: "add modified time to reputation",
+		Up: `
+ALTER TABLE reputation ADD COLUMN modified timestamp with time zone NOT NULL DEFAULT now();
+CREATE INDEX reputation_modified_idx ON reputation (modified);
+`,
+		Down: `
+ALTER TABLE reputation DROP COLUMN modified;
+`,
+	},
+	{
+		Version:     11,
+		Description: "create hawk_nonce table",
+		Up: `
+CREATE TABLE hawk_nonce (
+credential text NOT NULL,
+nonce text NOT NULL,
+timestamp timestamp with time zone NOT NULL,
+PRIMARY KEY (credential, nonce)
+);
+CREATE INDEX hawk_nonce_timestamp_idx ON hawk_nonce (timestamp);
+`,
+		Down: `
+DROP TABLE hawk_nonce;
+`,
+	},
+}
+
+const createSchemaVersionTableSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+version int PRIMARY KEY NOT NULL,
+description text NOT NULL,
+applied timestamp with time zone NOT NULL DEFAULT now()
+);
+`
+
+// LatestSchemaVersion returns the schema version this build of tigerblood expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the current schema version of the database, or 0 if no migrations
+// have been applied
+func (db DB) SchemaVersion() (int, error) {
+	return schemaVersion(db.QueryRow)
+}
+
+func schemaVersion(query func(string, ...interface{}) *sql.Row) (version int, err error) {
+	var exists bool
+	err = query("SELECT to_regclass('schema_version') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	err = query("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// MigrationStatus returns every known migration along with the time it was applied, if it
+// has been applied
+func (db DB) MigrationStatus() (ret []MigrationStatus, err error) {
+	applied := make(map[int]time.Time)
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+	if version > 0 {
+		rows, err := db.Query("SELECT version, applied FROM schema_version")
+		if err != nil {
+			return ret, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				v int
+				t time.Time
+			)
+			err = rows.Scan(&v, &t)
+			if err != nil {
+				return ret, err
+			}
+			applied[v] = t
+		}
+		err = rows.Err()
+		if err != nil {
+			return ret, err
+		}
+	}
+	for _, m := range migrations {
+		ret = append(ret, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     applied[m.Version],
+		})
+	}
+	return ret, nil
+}
+
+// migrate runs fn in a transaction holding the migration advisory lock, passing it the
+// schema version read after the lock was acquired
+func (db DB) migrate(fn func(tx *sql.Tx, version int) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(createSchemaVersionTableSQL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	version, err := schemaVersion(tx.QueryRow)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = fn(tx, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies all pending migrations in order. It is safe to call concurrently from
+// several instances; migrations are applied in a single transaction under an advisory lock.
+func (db DB) MigrateUp() error {
+	return db.migrate(func(tx *sql.Tx, version int) error {
+		if version > LatestSchemaVersion() {
+			return fmt.Errorf("Database schema version %d is newer than supported version %d",
+				version, LatestSchemaVersion())
+		}
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+			log.Printf("Applying migration %d: %s", m.Version, m.Description)
+			_, err := tx.Exec(m.Up)
+			if err != nil {
+				return fmt.Errorf("Migration %d failed: %s", m.Version, err)
+			}
+			_, err = tx.Exec("INSERT INTO schema_version (version, description) VALUES ($1, $2)",
+				m.Version, m.Description)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the most recently applied migration. It returns an error if no
+// migrations have been applied.
+func (db DB) MigrateDown() error {
+	return db.migrate(func(tx *sql.Tx, version int) error {
+		if version == 0 {
+			return fmt.Errorf("No migrations to revert")
+		}
+		if version > LatestSchemaVersion() {
+			return fmt.Errorf("Database schema version %d is newer than supported version %d",
+				version, LatestSchemaVersion())
+		}
+		m := migrations[version-1]
+		log.Printf("Reverting migration %d: %s", m.Version, m.Description)
+		_, err := tx.Exec(m.Down)
+		if err != nil {
+			return fmt.Errorf("Reverting migration %d failed: %s", m.Version, err)
+		}
+		_, err = tx.Exec("DELETE FROM schema_version WHERE version = $1", m.Version)
+		return err
+	})
+}
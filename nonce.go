@@ -0,0 +1,178 @@
+package tigerblood
+
+import (
+	"container/list"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.mozilla.org/hawk"
+	"sync"
+	"time"
+)
+
+// DefaultNonceCacheSize is the number of nonces an in-memory nonce store holds by default
+const DefaultNonceCacheSize = 100000
+
+// nonceStoreFullLogInterval is the minimum time between warnings that an in-memory nonce store
+// is full
+const nonceStoreFullLogInterval = time.Minute
+
+// ErrNonceStoreFull is returned by LRUNonceStore.Add when every nonce it holds is still within
+// the timestamp skew window, so no nonce can be evicted without allowing it to be replayed
+var ErrNonceStoreFull = errors.New("nonce store is full")
+
+var nonceStoreFullMetric = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "tigerblood",
+	Name:      "hawk_nonce_store_full_total",
+	Help:      "Hawk requests rejected because the in-memory nonce store was full.",
+})
+
+func init() {
+	metricsRegistry.MustRegister(nonceStoreFullMetric)
+}
+
+// NonceStore records the nonces of accepted Hawk requests so that they cannot be replayed
+type NonceStore interface {
+	// Add records the nonce of a request authenticated with the credential ID and timestamp
+	// t. It returns false if the nonce was already recorded for the credential.
+	Add(id string, nonce string, t time.Time) (bool, error)
+}
+
+// hawkNonces is the store used to detect replayed Hawk requests, or nil to not detect replays
+var hawkNonces NonceStore = NewLRUNonceStore(DefaultNonceCacheSize)
+
+// SetHawkNonceStore sets the store used to detect replayed Hawk requests. If s is nil replays
+// are not detected.
+func SetHawkNonceStore(s NonceStore) {
+	hawkNonces = s
+}
+
+// nonceExpiry returns the time before which nonces no longer need to be remembered: requests
+// with an older timestamp fail the Hawk timestamp skew check anyway
+func nonceExpiry(now time.Time) time.Time {
+	return now.Add(-hawk.MaxTimestampSkew)
+}
+
+// nonceKey identifies a nonce used by a credential
+type nonceKey struct {
+	id    string
+	nonce string
+}
+
+// nonceEntry is a nonce held by an LRUNonceStore
+type nonceEntry struct {
+	key nonceKey
+	t   time.Time
+}
+
+// LRUNonceStore is an in-memory NonceStore for a single instance. It holds at most size
+// nonces. Nonces are only removed once they are older than the timestamp skew window, so when
+// the store is full of newer nonces requests are rejected with ErrNonceStoreFull rather than
+// letting a nonce be replayed; size should exceed the number of Hawk requests expected within
+// the timestamp skew window.
+type LRUNonceStore struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[nonceKey]*list.Element
+	order    *list.List // Most recently used first
+	lastFull time.Time  // When a full store was last logged
+}
+
+// NewLRUNonceStore returns an in-memory nonce store holding at most size nonces
+func NewLRUNonceStore(size int) *LRUNonceStore {
+	return &LRUNonceStore{
+		size:    size,
+		entries: make(map[nonceKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Add implements NonceStore
+func (s *LRUNonceStore) Add(id string, nonce string, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(nonceExpiry(time.Now()))
+
+	key := nonceKey{id: id, nonce: nonce}
+	if e, ok := s.entries[key]; ok {
+		s.order.MoveToFront(e)
+		return false, nil
+	}
+	if s.order.Len() >= s.size {
+		// Nonces used again are moved to the front, so expired nonces may be left behind newer
+		// ones; look for them all before giving up
+		s.pruneAll(nonceExpiry(time.Now()))
+	}
+	if s.order.Len() >= s.size {
+		nonceStoreFullMetric.Inc()
+		if time.Since(s.lastFull) >= nonceStoreFullLogInterval {
+			s.lastFull = time.Now()
+			log.Warnf("hawk: nonce store full with %d nonces, rejecting requests", s.order.Len())
+		}
+		return false, ErrNonceStoreFull
+	}
+	s.entries[key] = s.order.PushFront(&nonceEntry{key: key, t: t})
+	return true, nil
+}
+
+// Len returns the number of nonces held
+func (s *LRUNonceStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// prune removes the least recently used nonces with a timestamp before expiry. Nonces are
+// added in roughly timestamp order, so expired nonces are found at the back of the list.
+func (s *LRUNonceStore) prune(expiry time.Time) {
+	for e := s.order.Back(); e != nil && e.Value.(*nonceEntry).t.Before(expiry); e = s.order.Back() {
+		s.remove(e)
+	}
+}
+
+// pruneAll removes every nonce with a timestamp before expiry
+func (s *LRUNonceStore) pruneAll(expiry time.Time) {
+	for e := s.order.Back(); e != nil; {
+		prev := e.Prev()
+		if e.Value.(*nonceEntry).t.Before(expiry) {
+			s.remove(e)
+		}
+		e = prev
+	}
+}
+
+func (s *LRUNonceStore) remove(e *list.Element) {
+	delete(s.entries, e.Value.(*nonceEntry).key)
+	s.order.Remove(e)
+}
+
+// DBNonceStore is a NonceStore kept in the hawk_nonce table, so that replicas share the
+// nonces they have seen. Expired nonces are removed by StartHawkNoncePurge.
+type DBNonceStore struct {
+	db *DB
+}
+
+// NewDBNonceStore returns a nonce store kept in the database
+func NewDBNonceStore(db *DB) *DBNonceStore {
+	return &DBNonceStore{db: db}
+}
+
+// Add implements NonceStore
+func (s *DBNonceStore) Add(id string, nonce string, t time.Time) (bool, error) {
+	return s.db.InsertHawkNonce(nil, id, nonce, t)
+}
+
+// StartHawkNoncePurge starts a routine that periodically removes expired nonces from the
+// hawk_nonce table. Purging is idempotent, so it can run on every replica.
+func StartHawkNoncePurge() {
+	go func() {
+		log.Printf("Starting hawk nonce purge routine (interval %s)", hawk.MaxTimestampSkew)
+		for {
+			_, err := db.DeleteHawkNoncesBefore(nil, nonceExpiry(time.Now()))
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Error removing hawk nonces: %s", err)
+			}
+			time.Sleep(hawk.MaxTimestampSkew)
+		}
+	}()
+}
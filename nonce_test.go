@@ -0,0 +1,83 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/hawk"
+	"testing"
+	"time"
+)
+
+func TestLRUNonceStore(t *testing.T) {
+	s := NewLRUNonceStore(2)
+	now := time.Now()
+
+	ok, err := s.Add("fxa", "abc", now)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = s.Add("fxa", "abc", now)
+	assert.False(t, ok)
+	// Nonces are per credential
+	ok, _ = s.Add("other", "abc", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Len())
+
+	// Nonces that could still be replayed are not evicted when the store is full
+	ok, err = s.Add("fxa", "def", now)
+	assert.Equal(t, ErrNonceStoreFull, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, s.Len())
+	ok, _ = s.Add("fxa", "abc", now)
+	assert.False(t, ok)
+}
+
+func TestLRUNonceStoreFullPrune(t *testing.T) {
+	s := NewLRUNonceStore(2)
+	old := time.Now().Add(-2 * hawk.MaxTimestampSkew)
+
+	ok, _ := s.Add("fxa", "abc", time.Now())
+	assert.True(t, ok)
+	// An expired nonce added later is in front of the newer one
+	ok, _ = s.Add("fxa", "def", old)
+	assert.True(t, ok)
+	// A full store removes expired nonces wherever they are
+	ok, err := s.Add("fxa", "ghi", time.Now())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Len())
+}
+
+func TestLRUNonceStorePrune(t *testing.T) {
+	s := NewLRUNonceStore(10)
+	old := time.Now().Add(-2 * hawk.MaxTimestampSkew)
+
+	ok, _ := s.Add("fxa", "abc", old)
+	assert.True(t, ok)
+	ok, _ = s.Add("fxa", "def", time.Now())
+	assert.True(t, ok)
+	// Nonces older than the timestamp skew are pruned
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestDBNonceStore(t *testing.T) {
+	assert.Nil(t, testDB.CreateTables())
+	assert.Nil(t, testDB.EmptyTables())
+	s := NewDBNonceStore(testDB)
+	now := time.Now()
+
+	ok, err := s.Add("fxa", "abc", now.Add(-2*hawk.MaxTimestampSkew))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = s.Add("fxa", "abc", now)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = s.Add("other", "abc", now)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	n, err := testDB.DeleteHawkNoncesBefore(nil, nonceExpiry(now))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	ok, err = s.Add("fxa", "abc", now)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
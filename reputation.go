@@ -0,0 +1,79 @@
+package tigerblood
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// defaultReputationListLimit is the number of entries returned by the reputation list endpoint
+// when no limit is given
+const defaultReputationListLimit = 100
+
+// ReputationListEntry is a reputation entry with the time it was last changed
+type ReputationListEntry struct {
+	ReputationEntry
+	Modified time.Time // When the entry was last changed
+}
+
+// ReputationFilter selects reputation entries in SelectReputationEntries. Zero valued fields other
+// than the reputation range are ignored.
+type ReputationFilter struct {
+	MinReputation  uint      // Only entries with at least this reputation
+	MaxReputation  uint      // Only entries with at most this reputation
+	Reviewed       *bool     // Only entries with this reviewed flag
+	Within         string    // Only entries for this address or network, or contained in it
+	ModifiedSince  time.Time // Only entries changed at or after this time
+	ModifiedBefore time.Time // Only entries changed before this time
+	After          string    // Only entries after this address in address order, for pagination
+}
+
+// SelectReputationEntries returns at most limit reputation entries matching filter, in address
+// order
+func (db DB) SelectReputationEntries(filter ReputationFilter, limit int) (ret []ReputationListEntry, err error) {
+	defer dbTiming("select_reputation_entries", time.Now())
+	var (
+		reviewed       sql.NullBool
+		within         sql.NullString
+		modifiedSince  pq.NullTime
+		modifiedBefore pq.NullTime
+		after          sql.NullString
+	)
+	if filter.Reviewed != nil {
+		reviewed = sql.NullBool{Bool: *filter.Reviewed, Valid: true}
+	}
+	if filter.Within != "" {
+		within = sql.NullString{String: filter.Within, Valid: true}
+	}
+	if !filter.ModifiedSince.IsZero() {
+		modifiedSince = pq.NullTime{Time: filter.ModifiedSince, Valid: true}
+	}
+	if !filter.ModifiedBefore.IsZero() {
+		modifiedBefore = pq.NullTime{Time: filter.ModifiedBefore, Valid: true}
+	}
+	if filter.After != "" {
+		after = sql.NullString{String: filter.After, Valid: true}
+	}
+	rows, err := db.Query("SELECT ip, reputation, reviewed, modified FROM reputation "+
+		"WHERE reputation >= $1 AND reputation <= $2 AND ($3::boolean IS NULL OR reviewed = $3) "+
+		"AND ($4::iprange IS NULL OR ip <<= $4) "+
+		"AND ($5::timestamptz IS NULL OR modified >= $5) "+
+		"AND ($6::timestamptz IS NULL OR modified < $6) "+
+		"AND ($7::iprange IS NULL OR ip > $7) "+
+		"ORDER BY ip LIMIT $8", filter.MinReputation, filter.MaxReputation, reviewed, within,
+		modifiedSince, modifiedBefore, after, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ReputationListEntry
+		err = rows.Scan(&e.IP, &e.Reputation, &e.Reviewed, &e.Modified)
+		if err != nil {
+			return
+		}
+		ret = append(ret, e)
+	}
+	err = rows.Err()
+	return
+}
@@ -216,3 +216,125 @@ func TestReadReputationReviewed(t *testing.T) {
 
 	assert.Nil(t, db.Close())
 }
+
+func TestLookupReputations(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+	SetMaxEntries(3)
+	defer SetMaxEntries(100)
+
+	_, err = db.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "127.0.0.0/8", Reputation: 50})
+	assert.Nil(t, err)
+	_, err = db.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "127.0.0.0/24", Reputation: 20,
+		Reviewed: true})
+	assert.Nil(t, err)
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{IP: "127.0.1.0/24", Creator: "test"}))
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("POST", "/reputations/lookup",
+		strings.NewReader(`["127.0.0.1", "127.0.1.1", "10.0.0.0/8"]`)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var lookups []ReputationLookup
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &lookups))
+	if assert.Equal(t, 3, len(lookups)) {
+		assert.Equal(t, ReputationLookup{IP: "127.0.0.1", Status: LookupFound, Subnet: "127.0.0.0/24",
+			Reputation: 20, Reviewed: true}, lookups[0])
+		assert.Equal(t, LookupExcepted, lookups[1].Status)
+		assert.Equal(t, uint(100), lookups[1].Reputation)
+		if assert.NotNil(t, lookups[1].Exception) {
+			assert.Equal(t, "127.0.1.0/24", lookups[1].Exception.IP)
+			assert.Equal(t, "test", lookups[1].Exception.Creator)
+		}
+		assert.Equal(t, ReputationLookup{IP: "10.0.0.0/8", Status: LookupUnknown, Reputation: 100},
+			lookups[2])
+	}
+
+	for _, body := range []string{`[]`, `["127.0.0.1", "garbage"]`, `{}`} {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("POST", "/reputations/lookup", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, body)
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("POST", "/reputations/lookup",
+		strings.NewReader(`["127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"]`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	assert.Nil(t, db.EmptyTables())
+	assert.Nil(t, db.Close())
+}
+
+func TestListReputations(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+	SetMaxEntries(100)
+
+	for _, e := range []ReputationEntry{
+		{IP: "10.0.0.1/32", Reputation: 10},
+		{IP: "10.0.0.2/32", Reputation: 20, Reviewed: true},
+		{IP: "10.0.1.0/24", Reputation: 30},
+		{IP: "192.168.0.1/32", Reputation: 90},
+	} {
+		_, err = db.InsertOrUpdateReputationEntry(nil, e)
+		assert.Nil(t, err)
+	}
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	list := func(query string) (ips []string, next string) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/reputations"+query, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, query)
+		var page struct {
+			Entries []ReputationListEntry
+			Next    string
+		}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		ips = []string{}
+		for _, e := range page.Entries {
+			assert.False(t, e.Modified.IsZero())
+			ips = append(ips, e.IP)
+		}
+		return ips, page.Next
+	}
+
+	ips, next := list("")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.1.0/24", "192.168.0.1"}, ips)
+	assert.Equal(t, "", next)
+
+	ips, next = list("?limit=2")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, ips)
+	assert.Equal(t, "10.0.0.2", next)
+	ips, next = list("?limit=2&after=" + next)
+	assert.Equal(t, []string{"10.0.1.0/24", "192.168.0.1"}, ips)
+	assert.Equal(t, "", next)
+
+	ips, _ = list("?min_reputation=15&max_reputation=50")
+	assert.Equal(t, []string{"10.0.0.2", "10.0.1.0/24"}, ips)
+	ips, _ = list("?reviewed=true")
+	assert.Equal(t, []string{"10.0.0.2"}, ips)
+	ips, _ = list("?within=10.0.0.0/16")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.1.0/24"}, ips)
+	ips, _ = list("?modified_before=2000-01-01T00:00:00Z")
+	assert.Equal(t, []string{}, ips)
+	ips, _ = list("?modified_since=2000-01-01T00:00:00Z&within=192.168.0.0/16")
+	assert.Equal(t, []string{"192.168.0.1"}, ips)
+
+	for _, q := range []string{"?min_reputation=101", "?reviewed=maybe", "?within=garbage",
+		"?modified_since=yesterday", "?after=garbage", "?limit=0"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/reputations"+q, nil))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, q)
+	}
+
+	assert.Nil(t, db.EmptyTables())
+	assert.Nil(t, db.Close())
+}
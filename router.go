@@ -30,7 +30,7 @@ func NewRouter() *mux.Router {
 	for _, route := range routes {
 		var handler http.Handler
 
-		handler = route.HandlerFunc
+		handler = instrumentRoute(route.Name, RequireScope(route.Scope, route.HandlerFunc))
 
 		router.
 			Methods(route.Method).
@@ -48,6 +48,7 @@ type Route struct {
 	Method      string
 	Pattern     string
 	HandlerFunc http.HandlerFunc
+	Scope       Scope // The scope a credential needs to use the route
 }
 
 // Routes is an array of Routes for configuring a Router
@@ -58,6 +59,7 @@ var UnauthedRoutes = map[string]bool{
 	"/__lbheartbeat__": true,
 	"/__heartbeat__":   true,
 	"/__version__":     true,
+	"/metrics":         true,
 }
 
 // UnauthedDebugRoutes are profiling routes that don't require hawk auth
@@ -79,36 +81,126 @@ var routes = Routes{
 		"GET",
 		"/__lbheartbeat__",
 		LoadBalancerHeartbeatHandler,
+		ScopeNone,
 	},
 	Route{
 		"Heartbeat",
 		"GET",
 		"/__heartbeat__",
 		HeartbeatHandler,
+		ScopeNone,
 	},
 	Route{
 		"Version",
 		"GET",
 		"/__version__",
 		VersionHandler,
+		ScopeNone,
+	},
+	Route{
+		"Metrics",
+		"GET",
+		"/metrics",
+		MetricsHandler,
+		ScopeNone,
+	},
+	Route{
+		"ListReputations",
+		"GET",
+		"/reputations",
+		ListReputationsHandler,
+		ScopeRead,
+	},
+	Route{
+		"LookupReputations",
+		"POST",
+		"/reputations/lookup",
+		LookupReputationsHandler,
+		ScopeRead,
+	},
+	Route{
+		"Export",
+		"GET",
+		"/export",
+		ExportHandler,
+		ScopeRead,
 	},
 	Route{
 		"ListViolations",
 		"GET",
 		"/violations",
 		ListViolationsHandler,
+		ScopeRead,
 	},
 	Route{
 		"ListExceptions",
 		"GET",
 		"/exceptions",
 		ListExceptionsHandler,
+		ScopeRead,
+	},
+	Route{
+		"CreateException",
+		"POST",
+		"/exceptions",
+		CreateExceptionHandler,
+		ScopeAdmin,
+	},
+	Route{
+		"DeleteException",
+		"DELETE",
+		"/exceptions/{ip:[[:punct:]\\/\\.\\w]{1,128}}",
+		DeleteExceptionHandler,
+		ScopeAdmin,
+	},
+	Route{
+		"ListViolationTypes",
+		"GET",
+		"/violations/types",
+		ListViolationTypesHandler,
+		ScopeRead,
+	},
+	Route{
+		"CreateViolationType",
+		"POST",
+		"/violations/types/{name:[[:punct:]\\w]{1,255}}",
+		CreateViolationTypeHandler,
+		ScopeAdmin,
+	},
+	Route{
+		"UpdateViolationType",
+		"PUT",
+		"/violations/types/{name:[[:punct:]\\w]{1,255}}",
+		UpdateViolationTypeHandler,
+		ScopeAdmin,
+	},
+	Route{
+		"DeleteViolationType",
+		"DELETE",
+		"/violations/types/{name:[[:punct:]\\w]{1,255}}",
+		DeleteViolationTypeHandler,
+		ScopeAdmin,
 	},
 	Route{
 		"MultiUpsertReputationByViolation",
 		"PUT",
 		"/violations/",
 		MultiUpsertReputationByViolationHandler,
+		ScopeViolate,
+	},
+	Route{
+		"Audit",
+		"GET",
+		"/audit",
+		AuditHandler,
+		ScopeAdmin,
+	},
+	Route{
+		"ViolationHistory",
+		"GET",
+		"/violations/{ip:[[:punct:]\\/\\.\\w]{1,128}}",
+		ViolationHistoryHandler,
+		ScopeRead,
 	},
 	Route{
 		"ReadReputation",
@@ -116,23 +208,27 @@ var routes = Routes{
 		// include all :punct: since gorilla/mux barfed trying to limit it to `:` (or as \x3a)
 		"/{ip:[[:punct:]\\/\\.\\w]{1,128}}",
 		ReadReputationHandler,
+		ScopeRead,
 	},
 	Route{
 		"UpsertReputationByViolation",
 		"PUT",
 		"/violations/{type:[[:punct:]\\w]{1,255}}",
 		UpsertReputationByViolationHandler,
+		ScopeViolate,
 	},
 	Route{
 		"UpdateReputation",
 		"PUT",
 		"/{ip:[[:punct:]\\/\\.\\w]{1,128}}",
 		UpdateReputationHandler,
+		ScopeAdmin,
 	},
 	Route{
 		"DeleteReputation",
 		"DELETE",
 		"/{ip:[[:punct:]\\/\\.\\w]{1,128}}",
 		DeleteReputationHandler,
+		ScopeAdmin,
 	},
 }
@@ -0,0 +1,143 @@
+package tigerblood
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is a permission a credential needs to use a route
+type Scope string
+
+// Scopes that can be granted to credentials
+const (
+	// ScopeNone is for routes that do not require authentication
+	ScopeNone Scope = ""
+	// ScopeRead allows reputation, violation and exception lookups
+	ScopeRead Scope = "read"
+	// ScopeViolate allows reporting violations, optionally restricted to named violations
+	ScopeViolate Scope = "violate"
+	// ScopeAdmin allows everything, including setting and deleting reputations directly
+	ScopeAdmin Scope = "admin"
+)
+
+// scopeGrant is the set of scopes granted to a credential
+type scopeGrant struct {
+	read       bool
+	violate    bool
+	admin      bool
+	violations map[string]bool // The violations the credential may report, or nil for all
+}
+
+// credentialScopes maps credential IDs to the scopes granted to them
+var credentialScopes map[string]scopeGrant
+
+// parseScopes parses a comma separated list of scopes. Each scope is read, violate, admin or
+// violate:<violation>; the latter restricts violation reporting to the named violations and may
+// be given more than once.
+func parseScopes(s string) (scopeGrant, error) {
+	var g scopeGrant
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == string(ScopeRead):
+			g.read = true
+		case v == string(ScopeAdmin):
+			g.admin = true
+		case v == string(ScopeViolate):
+			g.violate = true
+			g.violations = nil
+		case strings.HasPrefix(v, string(ScopeViolate)+":") && len(v) > len(ScopeViolate)+1:
+			if g.violate && g.violations == nil {
+				// Already allowed to report every violation
+				continue
+			}
+			g.violate = true
+			if g.violations == nil {
+				g.violations = make(map[string]bool)
+			}
+			g.violations[strings.TrimPrefix(v, string(ScopeViolate)+":")] = true
+		default:
+			return g, fmt.Errorf("Invalid scope %q", v)
+		}
+	}
+	return g, nil
+}
+
+// SetCredentialScopes configures the scopes granted to each credential ID, as comma separated
+// lists parsed by parseScopes. Credentials without configured scopes are granted nothing.
+func SetCredentialScopes(scopes map[string]string) error {
+	grants := make(map[string]scopeGrant, len(scopes))
+	for id, s := range scopes {
+		g, err := parseScopes(s)
+		if err != nil {
+			return fmt.Errorf("Error parsing scopes for credential %s: %s", id, err)
+		}
+		grants[id] = g
+	}
+	credentialsLock.Lock()
+	credentialScopes = grants
+	credentialsLock.Unlock()
+	return nil
+}
+
+// grantForCredential returns the scopes granted to a credential ID. Requests that were not
+// authenticated and credentials without configured scopes are granted nothing.
+func grantForCredential(id string) scopeGrant {
+	credentialsLock.RLock()
+	defer credentialsLock.RUnlock()
+	return credentialScopes[id]
+}
+
+// has returns true if the grant includes the scope
+func (g scopeGrant) has(scope Scope) bool {
+	switch scope {
+	case ScopeNone:
+		return true
+	case ScopeRead:
+		return g.admin || g.read
+	case ScopeViolate:
+		return g.admin || g.violate
+	}
+	return g.admin
+}
+
+// canReport returns true if the grant allows reporting the violation
+func (g scopeGrant) canReport(violation string) bool {
+	if g.admin {
+		return true
+	}
+	return g.violate && (g.violations == nil || g.violations[violation])
+}
+
+// CanReportViolation returns true if the credential the request was authenticated with may
+// report the violation. It is always true when authentication is disabled.
+func CanReportViolation(ctx context.Context, violation string) bool {
+	if authModes == 0 {
+		return true
+	}
+	return grantForCredential(PrincipalFromContext(ctx)).canReport(violation)
+}
+
+// RequireScope wraps the handler for a route to reject requests authenticated with a
+// credential that was not granted the scope. Nothing is checked when authentication is
+// disabled or the route does not require a scope.
+func RequireScope(scope Scope, h http.Handler) http.Handler {
+	if scope == ScopeNone {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authModes == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id := PrincipalFromContext(r.Context())
+		if !grantForCredential(id).has(scope) {
+			requestLog(r, InsufficientScopeError).Warnf(DescribeErrno(InsufficientScopeError), id, scope)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package tigerblood
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	g, err := parseScopes("read, violate:rate_limit_exceeded,violate:password-spray")
+	assert.Nil(t, err)
+	assert.True(t, g.has(ScopeRead))
+	assert.True(t, g.has(ScopeViolate))
+	assert.False(t, g.has(ScopeAdmin))
+	assert.True(t, g.canReport("rate_limit_exceeded"))
+	assert.True(t, g.canReport("password-spray"))
+	assert.False(t, g.canReport("test_violation"))
+
+	g, err = parseScopes("violate:rate_limit_exceeded,violate")
+	assert.Nil(t, err)
+	assert.False(t, g.has(ScopeRead))
+	assert.True(t, g.canReport("test_violation"))
+
+	g, err = parseScopes("admin")
+	assert.Nil(t, err)
+	assert.True(t, g.has(ScopeRead))
+	assert.True(t, g.has(ScopeAdmin))
+	assert.True(t, g.canReport("test_violation"))
+
+	for _, s := range []string{"", "write", "violate:", "read,,admin"} {
+		_, err = parseScopes(s)
+		assert.NotNil(t, err, s)
+	}
+	assert.NotNil(t, SetCredentialScopes(map[string]string{"test": "root"}))
+}
+
+func TestRequireScope(t *testing.T) {
+	SetDB(nil)
+	SetAPIKeyCredentials(map[string]string{"reader": "key1", "reporter": "key2", "unscoped": "key3"})
+	assert.Nil(t, SetCredentialScopes(map[string]string{"reader": "read", "reporter": "violate:test"}))
+	defer SetCredentialScopes(nil)
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+	h := HandleWithMiddleware(NewRouter(), []Middleware{RequireAuth()})
+
+	do := func(method string, path string, key string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "APIKey "+key)
+		h.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	// Requests that pass the scope check fail without a database
+	assert.Equal(t, http.StatusInternalServerError, do("GET", "/10.0.0.1", "key1"))
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/10.0.0.1", "key1"))
+	assert.Equal(t, http.StatusForbidden, do("GET", "/10.0.0.1", "key2"))
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/10.0.0.1", "key2"))
+	assert.Equal(t, http.StatusForbidden, do("GET", "/audit", "key2"))
+	assert.Equal(t, http.StatusForbidden, do("GET", "/10.0.0.1", "key3"))
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/10.0.0.1", "key3"))
+	assert.Equal(t, http.StatusOK, do("GET", "/__lbheartbeat__", "key2"))
+
+	SetAuthMask(0)
+	assert.Equal(t, http.StatusInternalServerError, do("DELETE", "/10.0.0.1", ""))
+}
+
+func TestCanReportViolation(t *testing.T) {
+	assert.Nil(t, SetCredentialScopes(map[string]string{"reader": "read", "reporter": "violate:test"}))
+	defer SetCredentialScopes(nil)
+	ctx := func(id string) context.Context {
+		return context.WithValue(context.Background(), principalContextKey, id)
+	}
+
+	SetAuthMask(0)
+	assert.True(t, CanReportViolation(ctx("reader"), "test"))
+
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+	assert.True(t, CanReportViolation(ctx("reporter"), "test"))
+	assert.False(t, CanReportViolation(ctx("reporter"), "other"))
+	assert.False(t, CanReportViolation(ctx("reader"), "test"))
+	assert.False(t, CanReportViolation(ctx("unscoped"), "other"))
+	assert.False(t, CanReportViolation(context.Background(), "test"))
+}
@@ -0,0 +1,87 @@
+package tigerblood
+
+import (
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// statsdTiming sends the time elapsed since start as a statsd timer, if a statsd client is
+// configured
+func statsdTiming(name string, start time.Time, tags ...string) {
+	if statsdClient == nil {
+		return
+	}
+	statsdClient.Timing(name, time.Since(start), tags, 1)
+}
+
+// statsdIncr increments a statsd counter, if a statsd client is configured
+func statsdIncr(name string, tags ...string) {
+	if statsdClient == nil {
+		return
+	}
+	statsdClient.Incr(name, tags, 1)
+}
+
+// statsdGauge sends a statsd gauge, if a statsd client is configured
+func statsdGauge(name string, value float64, tags ...string) {
+	if statsdClient == nil {
+		return
+	}
+	statsdClient.Gauge(name, value, tags, 1)
+}
+
+// statsdRequest records the timing and response status code of a request to the named route
+func statsdRequest(route string, method string, status int, start time.Time) {
+	statsdTiming("request.timing", start, "route:"+route, "method:"+method)
+	statsdIncr("request.status", "route:"+route, "method:"+method, "code:"+strconv.Itoa(status))
+}
+
+// dbTiming records the time spent in the named DB call. Call it with defer at the start of
+// the call.
+func dbTiming(query string, start time.Time) {
+	statsdTiming("db.timing", start, "query:"+query)
+}
+
+// sendTableGauges sends the number of reputation entries and exceptions as statsd gauges
+func sendTableGauges() error {
+	n, err := db.CountReputationEntries()
+	if err != nil {
+		return err
+	}
+	statsdGauge("reputation.entries", float64(n))
+
+	counts, err := db.CountExceptionsByCreator()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for creator, c := range counts {
+		statsdGauge("exception.entries_by_creator", float64(c), "creator:"+creator)
+		total += c
+	}
+	statsdGauge("exception.entries", float64(total))
+	return nil
+}
+
+// StartTableGauges starts a routine that sends the reputation and exception table sizes to
+// statsd every interval
+func StartTableGauges(interval time.Duration) {
+	if interval <= 0 {
+		log.Fatalf("Invalid statsd gauge interval: %s", interval)
+	}
+	if statsdClient == nil {
+		log.WithFields(log.Fields{"errno": MissingStatsdClient}).Warnf("%s", DescribeErrno(MissingStatsdClient))
+		return
+	}
+	go func() {
+		log.Printf("Starting table size gauge routine (every %s)", interval)
+		for {
+			err := sendTableGauges()
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Error counting table sizes: %s", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package tigerblood
+
+import (
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdRequestMetrics(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	client, err := statsd.New(conn.LocalAddr().String())
+	assert.Nil(t, err)
+	client.Namespace = "tigerblood."
+	SetStatsdClient(client)
+	defer SetStatsdClient(nil)
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	req := httptest.NewRequest("GET", "/__lbheartbeat__", nil)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var received []string
+	buf := make([]byte, 1024)
+	for len(received) < 2 {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			break
+		}
+		received = append(received, strings.Split(string(buf[:n]), "\n")...)
+	}
+	all := strings.Join(received, "\n")
+	assert.Contains(t, all, "tigerblood.request.timing:")
+	assert.Contains(t, all, "#route:LoadBalancerHeartbeat,method:GET")
+	assert.Contains(t, all, "tigerblood.request.status:1|c|#route:LoadBalancerHeartbeat,method:GET,code:200")
+}
@@ -0,0 +1,183 @@
+package tigerblood
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.mozilla.org/mozlogrus"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// summaryLogger writes request.summary events, which have their own MozLog type
+var summaryLogger = &log.Logger{
+	Out:       os.Stdout,
+	Formatter: &mozlogrus.MozLogFormatter{LoggerName: "tigerblood", Type: "request.summary"},
+	Hooks:     make(log.LevelHooks),
+	Level:     log.InfoLevel,
+}
+
+// trustedProxies are the networks of proxies whose X-Forwarded-For header is used to find the
+// client address
+var trustedProxies []*net.IPNet
+var trustedProxiesLock sync.RWMutex
+
+// requestSummary holds the details of a request that are only known to inner handlers, so they
+// can be included in its request.summary event
+type requestSummary struct {
+	sync.Mutex
+	route     string
+	principal string
+	errno     Errno
+}
+
+// summaryFromContext returns the request summary RequestSummary added to the context, or nil
+func summaryFromContext(ctx context.Context) *requestSummary {
+	s, _ := ctx.Value(summaryContextKey).(*requestSummary)
+	return s
+}
+
+// SetRequestErrno records errno as the error number for the request summary of the request
+// with context ctx. Later calls replace the errno of earlier ones.
+func SetRequestErrno(ctx context.Context, errno Errno) {
+	if s := summaryFromContext(ctx); s != nil {
+		s.Lock()
+		s.errno = errno
+		s.Unlock()
+	}
+}
+
+// requestLog records errno for the request summary of r and returns a log entry with the errno
+// field set
+func requestLog(r *http.Request, errno Errno) *log.Entry {
+	SetRequestErrno(r.Context(), errno)
+	return log.WithFields(log.Fields{"errno": errno})
+}
+
+// setRequestRoute records the name of the route that handled the request
+func setRequestRoute(ctx context.Context, route string) {
+	if s := summaryFromContext(ctx); s != nil {
+		s.Lock()
+		s.route = route
+		s.Unlock()
+	}
+}
+
+// setRequestPrincipal records the credential ID the request was authenticated with
+func setRequestPrincipal(ctx context.Context, principal string) {
+	if s := summaryFromContext(ctx); s != nil {
+		s.Lock()
+		s.principal = principal
+		s.Unlock()
+	}
+}
+
+// SetTrustedProxies sets the networks of the proxies in front of tigerblood. The client address
+// in request summaries is taken from the X-Forwarded-For header only for hops through these
+// proxies.
+func SetTrustedProxies(cidrs []string) error {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("Invalid trusted proxy %s: %s", c, err)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxiesLock.Lock()
+	trustedProxies = nets
+	trustedProxiesLock.Unlock()
+	return nil
+}
+
+// isTrustedProxy returns true if addr is in one of the trusted proxy networks
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	trustedProxiesLock.RLock()
+	defer trustedProxiesLock.RUnlock()
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddressChain returns the addresses a request passed through, from the client to the
+// peer that connected to tigerblood: the X-Forwarded-For addresses followed by the peer address
+func remoteAddressChain(r *http.Request) []string {
+	var chain []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, a := range strings.Split(h, ",") {
+			a = strings.TrimSpace(a)
+			if a != "" {
+				chain = append(chain, a)
+			}
+		}
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	return append(chain, peer)
+}
+
+// clientAddress returns the address of the client that made the request. X-Forwarded-For
+// addresses are followed back from the peer address only while they were added by a trusted
+// proxy, so clients cannot spoof their address by sending the header themselves.
+func clientAddress(chain []string) string {
+	i := len(chain) - 1
+	for i > 0 && isTrustedProxy(chain[i]) {
+		i--
+	}
+	return chain[i]
+}
+
+// RequestSummary is middleware that logs a MozLog request.summary event for each request. It
+// should be the first middleware so the summary covers authentication failures, and includes the
+// route name, authenticated credential and errno set by the handlers.
+func RequestSummary() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			s := &requestSummary{}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), summaryContextKey, s)))
+
+			chain := remoteAddressChain(r)
+			s.Lock()
+			defer s.Unlock()
+			summaryLogger.WithFields(log.Fields{
+				"agent":              r.UserAgent(),
+				"method":             r.Method,
+				"path":               r.URL.Path,
+				"route":              s.route,
+				"code":               rec.status,
+				"errno":              s.errno,
+				"t":                  int64(time.Since(start) / time.Millisecond),
+				"uid":                s.principal,
+				"remoteAddr":         clientAddress(chain),
+				"remoteAddressChain": chain,
+				"lang":               r.Header.Get("Accept-Language"),
+			}).Info("")
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package tigerblood
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// serveWithSummary serves req through RequestSummary, RequireAuth and the router and returns
+// the fields of the request.summary event
+func serveWithSummary(t *testing.T, req *http.Request) map[string]interface{} {
+	var buf bytes.Buffer
+	summaryLogger.Out = &buf
+	defer func() { summaryLogger.Out = os.Stdout }()
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{RequestSummary(), RequireAuth()})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var event struct {
+		Type   string
+		Fields map[string]interface{}
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &event))
+	assert.Equal(t, "request.summary", event.Type)
+	return event.Fields
+}
+
+func TestRequestSummary(t *testing.T) {
+	SetAuthMask(AuthEnableAPIKey)
+	SetAPIKeyCredentials(map[string]string{"test": "valid_key"})
+	defer SetAuthMask(0)
+	assert.Nil(t, SetCredentialScopes(map[string]string{"test": "read"}))
+	defer SetCredentialScopes(nil)
+
+	req := httptest.NewRequest("GET", "/__lbheartbeat__", nil)
+	fields := serveWithSummary(t, req)
+	assert.Equal(t, "GET", fields["method"])
+	assert.Equal(t, "/__lbheartbeat__", fields["path"])
+	assert.Equal(t, "LoadBalancerHeartbeat", fields["route"])
+	assert.Equal(t, float64(http.StatusOK), fields["code"])
+	assert.Equal(t, float64(0), fields["errno"])
+
+	req = httptest.NewRequest("GET", "/240.0.0.1", nil)
+	req.Header.Set("Authorization", "APIKey invalid_key")
+	fields = serveWithSummary(t, req)
+	assert.Equal(t, float64(http.StatusUnauthorized), fields["code"])
+	assert.Equal(t, float64(APIKeyInvalid), fields["errno"])
+	assert.Equal(t, "", fields["uid"])
+
+	SetViolationPenalties(nil)
+	req = httptest.NewRequest("GET", "/violations", nil)
+	req.Header.Set("Authorization", "APIKey valid_key")
+	fields = serveWithSummary(t, req)
+	assert.Equal(t, "ListViolations", fields["route"])
+	assert.Equal(t, float64(http.StatusInternalServerError), fields["code"])
+	assert.Equal(t, float64(MissingViolations), fields["errno"])
+	assert.Equal(t, "test", fields["uid"])
+}
+
+func TestRequestSummaryClientAddress(t *testing.T) {
+	assert.Nil(t, SetTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}))
+	defer SetTrustedProxies(nil)
+	assert.NotNil(t, SetTrustedProxies([]string{"not an address"}))
+
+	req := httptest.NewRequest("GET", "/__lbheartbeat__", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.5, 192.0.2.1")
+	fields := serveWithSummary(t, req)
+	assert.Equal(t, "203.0.113.5", fields["remoteAddr"])
+	assert.Equal(t, []interface{}{"198.51.100.7", "203.0.113.5", "192.0.2.1", "10.1.2.3"},
+		fields["remoteAddressChain"])
+
+	// The header is ignored when the peer is not a trusted proxy
+	req.RemoteAddr = "203.0.113.9:1234"
+	fields = serveWithSummary(t, req)
+	assert.Equal(t, "203.0.113.9", fields["remoteAddr"])
+
+	// All hops are trusted proxies, so the first address is the client
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "10.9.9.9")
+	fields = serveWithSummary(t, req)
+	assert.Equal(t, "10.9.9.9", fields["remoteAddr"])
+}
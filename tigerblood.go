@@ -6,12 +6,16 @@ import (
 	log "github.com/sirupsen/logrus"
 	"go.mozilla.org/mozlogrus"
 	"runtime"
+	"sync"
+	"time"
 )
 
 var db *DB
 var statsdClient *statsd.Client
 var violationPenalties map[string]uint
 var violationPenaltiesJSON []byte
+var violationLock sync.RWMutex
+var violationRecovery map[string]time.Duration
 var useProfileHandlers = false
 var maxEntries = int(100)
 var exceptionSources []exceptionSource
@@ -55,7 +59,8 @@ func SetStatsdClient(newClient *statsd.Client) {
 	statsdClient = newClient
 }
 
-// SetViolationPenalties sets or updates the violation penalties map
+// SetViolationPenalties sets or updates the violation penalties map. It is safe to call while
+// requests are being served.
 func SetViolationPenalties(newPenalties map[string]uint) {
 	for violationType, penalty := range newPenalties {
 		if !IsValidViolationName(violationType) {
@@ -65,17 +70,44 @@ func SetViolationPenalties(newPenalties map[string]uint) {
 			log.Fatalf("Invalid violation penalty: %d", penalty)
 		}
 	}
-	violationPenalties = newPenalties
 
 	// set violationPenaltiesJSON
-	json, err := json.Marshal(violationPenalties)
+	json, err := json.Marshal(newPenalties)
 	if err != nil {
 		log.WithFields(log.Fields{"errno": JSONMarshalError}).Fatalf(DescribeErrno(JSONMarshalError),
 			"violations", err)
 	}
+
+	violationLock.Lock()
+	defer violationLock.Unlock()
+	violationPenalties = newPenalties
 	violationPenaltiesJSON = json
 }
 
+// currentViolationPenalties returns the violation penalties map and its JSON encoding. The
+// returned values are replaced rather than modified by SetViolationPenalties, so callers may
+// use them without holding the lock.
+func currentViolationPenalties() (map[string]uint, []byte) {
+	violationLock.RLock()
+	defer violationLock.RUnlock()
+	return violationPenalties, violationPenaltiesJSON
+}
+
+// SetViolationRecovery sets or updates the recovery period for each violation type. Penalties
+// for violation types with a recovery period are restored linearly over that period by the decay
+// routine; other violation types recover at the default decay rate.
+func SetViolationRecovery(newRecovery map[string]time.Duration) {
+	for violationType, recovery := range newRecovery {
+		if !IsValidViolationName(violationType) {
+			log.Fatalf("Invalid violation type: %s", violationType)
+		}
+		if recovery <= 0 {
+			log.Fatalf("Invalid violation recovery period for %s: %s", violationType, recovery)
+		}
+	}
+	violationRecovery = newRecovery
+}
+
 // SetMaxEntries updates the maximum number of entries in multi entry handlers
 func SetMaxEntries(newMaxEntries int) {
 	if newMaxEntries < 0 {
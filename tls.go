@@ -0,0 +1,177 @@
+package tigerblood
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Certificate identity types for mTLS credentials
+const (
+	mtlsIdentityCN    = "cn"    // The subject common name
+	mtlsIdentityDNS   = "dns"   // A DNS name SAN
+	mtlsIdentityURI   = "uri"   // A URI SAN, such as a SPIFFE ID
+	mtlsIdentityEmail = "email" // An email address SAN
+)
+
+// MTLSData is mTLS config data mapping client certificate identities to principals
+type MTLSData struct {
+	principals map[string]string // Identities, as <type>:<value>, to principals
+}
+
+// NewMTLSData returns mTLS config data from a map of principals to the client certificate
+// identity they are authenticated by. An identity is <type>:<value>, where type is cn for the
+// subject common name, or dns, uri or email for a subject alternative name.
+func NewMTLSData(credentials map[string]string) (*MTLSData, error) {
+	principals := make(map[string]string, len(credentials))
+	for principal, identity := range credentials {
+		parts := strings.SplitN(identity, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid certificate identity for %s: %s", principal, identity)
+		}
+		switch parts[0] {
+		case mtlsIdentityCN, mtlsIdentityDNS, mtlsIdentityURI, mtlsIdentityEmail:
+		default:
+			return nil, fmt.Errorf("Invalid certificate identity type for %s: %s", principal, parts[0])
+		}
+		if other, ok := principals[identity]; ok {
+			return nil, fmt.Errorf("Certificate identity %s is used by both %s and %s", identity,
+				principal, other)
+		}
+		principals[identity] = principal
+	}
+	return &MTLSData{principals: principals}, nil
+}
+
+// SetMTLSData configures the client certificate identities used for mTLS authentication
+func SetMTLSData(d *MTLSData) {
+	credentialsLock.Lock()
+	mtlsData = d
+	credentialsLock.Unlock()
+}
+
+// certificateIdentities returns the identities of a certificate that can be mapped to principals
+func certificateIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, mtlsIdentityCN+":"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, mtlsIdentityDNS+":"+name)
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, mtlsIdentityURI+":"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		ids = append(ids, mtlsIdentityEmail+":"+email)
+	}
+	return ids
+}
+
+// authenticateMTLS authenticates requests by their verified client certificate, returning the
+// principal the certificate maps to and true if successful
+func authenticateMTLS(r *http.Request, m *MTLSData) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		requestLog(r, MTLSNoCertificate).Warnf("mtls: no verified client certificate")
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, id := range certificateIdentities(cert) {
+		if principal, ok := m.principals[id]; ok {
+			log.WithFields(log.Fields{"id": principal}).Infof("mtls: accepted request")
+			return principal, true
+		}
+	}
+	requestLog(r, MTLSUnknownCertificate).Warnf("mtls: unknown client certificate %s", cert.Subject)
+	return "", false
+}
+
+// ReloadingTLSConfig is a TLS server configuration loaded from files that can be reloaded
+// without restarting the listener. Connections made after Reload use the new certificate and
+// client CAs.
+type ReloadingTLSConfig struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+// NewReloadingTLSConfig loads the server certificate and key, and if clientCAFile is not empty
+// the CA certificates client certificates are verified against. Client certificates are
+// optional, so that clients can also authenticate with Hawk or API keys.
+func NewReloadingTLSConfig(certFile, keyFile, clientCAFile string) (*ReloadingTLSConfig, error) {
+	c := &ReloadingTLSConfig{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	return c, c.Reload()
+}
+
+// Reload reloads the certificate, key and client CAs from their files. The current
+// configuration is kept if an error is returned.
+func (c *ReloadingTLSConfig) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error loading TLS certificate: %s", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("Error loading TLS client CAs: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificates found in TLS client CA file %s", c.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ReloadingTLSConfig) current() *tls.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// TLSConfig returns a configuration for an http.Server that uses the most recently loaded
+// certificate and client CAs for each connection. Each connection gets a copy of the returned
+// configuration with the loaded certificate, so that its other settings, such as the NextProtos
+// that enable HTTP/2, are kept.
+func (c *ReloadingTLSConfig) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Negotiate HTTP/2 as net/http does by default
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &c.current().Certificates[0], nil
+		},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		current := c.current()
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.GetCertificate = nil
+		config.Certificates = current.Certificates
+		config.ClientCAs = current.ClientCAs
+		config.ClientAuth = current.ClientAuth
+		return config, nil
+	}
+	return base
+}
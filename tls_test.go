@@ -0,0 +1,262 @@
+package tigerblood
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate and key for TLS tests
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCertificate creates a certificate from template, signed by parent or self-signed if
+// parent is nil
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (c *testCertificate) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestNewMTLSData(t *testing.T) {
+	_, err := NewMTLSData(map[string]string{"fxa": "uri:spiffe://mesh/fxa", "addons": "cn:addons"})
+	assert.Nil(t, err)
+
+	for _, identity := range []string{"", "fxa", "cn:", "serial:1234"} {
+		_, err = NewMTLSData(map[string]string{"fxa": identity})
+		assert.NotNil(t, err, identity)
+	}
+	_, err = NewMTLSData(map[string]string{"fxa": "cn:fxa", "other": "cn:fxa"})
+	assert.NotNil(t, err)
+}
+
+func TestMTLSAuth(t *testing.T) {
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	newServerCert := func() *testCertificate {
+		return newTestCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "tigerblood"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca)
+	}
+	spiffe, _ := url.Parse("spiffe://mesh/fxa")
+	newClientCert := func(cn string, uris []*url.URL) tls.Certificate {
+		return newTestCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			URIs:        uris,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca).tlsCertificate(t)
+	}
+
+	dir, err := ioutil.TempDir("", "tigerblood-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeServerCert := func(c *testCertificate) {
+		assert.Nil(t, ioutil.WriteFile(certFile, c.pem, 0600))
+		assert.Nil(t, ioutil.WriteFile(keyFile, c.keyPEM(t), 0600))
+	}
+	serverCert := newServerCert()
+	writeServerCert(serverCert)
+	assert.Nil(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+
+	tlsConfig, err := NewReloadingTLSConfig(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+	md, err := NewMTLSData(map[string]string{"fxa": "uri:spiffe://mesh/fxa", "addons": "cn:addons"})
+	assert.Nil(t, err)
+	SetMTLSData(md)
+	SetAPIKeyCredentials(map[string]string{"test": "valid_key"})
+	SetAuthMask(AuthEnableMTLS | AuthEnableAPIKey)
+	defer SetAuthMask(0)
+
+	server := httptest.NewUnstartedServer(HandleWithMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(PrincipalFromContext(r.Context())))
+		}), []Middleware{RequireAuth()}))
+	server.TLS = tlsConfig.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	do := func(cert *tls.Certificate, apiKey string) (int, string, *x509.Certificate) {
+		config := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		req, err := http.NewRequest("GET", server.URL, nil)
+		assert.Nil(t, err)
+		if apiKey != "" {
+			req.Header.Set("Authorization", "APIKey "+apiKey)
+		}
+		resp, err := client.Do(req)
+		if !assert.Nil(t, err) {
+			return 0, "", nil
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return resp.StatusCode, string(body), resp.TLS.PeerCertificates[0]
+	}
+
+	fxa := newClientCert("fxa-auth", []*url.URL{spiffe})
+	code, principal, served := do(&fxa, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "fxa", principal)
+	assert.Equal(t, serverCert.cert.SerialNumber, served.SerialNumber)
+
+	addons := newClientCert("addons", nil)
+	code, principal, _ = do(&addons, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "addons", principal)
+
+	unknown := newClientCert("unknown", nil)
+	code, _, _ = do(&unknown, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Certificates from another CA are rejected during the handshake
+	untrusted := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "addons"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil).tlsCertificate(t)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			// Send the certificate even though the server does not list its CA
+			return &untrusted, nil
+		},
+	}}}
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	// Without a client certificate other authentication modes still work
+	code, _, _ = do(nil, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, principal, _ = do(nil, "valid_key")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test", principal)
+
+	// A reloaded certificate is used for new connections
+	newCert := newServerCert()
+	writeServerCert(newCert)
+	assert.Nil(t, tlsConfig.Reload())
+	_, _, served = do(&fxa, "")
+	assert.Equal(t, newCert.cert.SerialNumber, served.SerialNumber)
+
+	// A failed reload keeps the current certificate
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+	assert.NotNil(t, tlsConfig.Reload())
+	_, _, served = do(&fxa, "")
+	assert.Equal(t, newCert.cert.SerialNumber, served.SerialNumber)
+}
+
+func TestReloadingTLSConfigHTTP2(t *testing.T) {
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	cert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "tigerblood"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	dir, err := ioutil.TempDir("", "tigerblood-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, cert.pem, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, cert.keyPEM(t), 0600))
+	assert.Nil(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+	tlsConfig, err := NewReloadingTLSConfig(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+
+	// Serve like tigerblood does, so that the server configures HTTP/2
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: tlsConfig.TLSConfig(),
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, c := range []struct {
+		http2 bool
+		proto string
+	}{
+		{true, "HTTP/2.0"},
+		{false, "HTTP/1.1"},
+	} {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: c.http2}
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + listener.Addr().String())
+		if !assert.Nil(t, err) {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, c.proto, string(body))
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,159 @@
+package tigerblood
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const torExitListURL = "https://check.torproject.org/torbulkexitlist"
+const torUpdateInterval = time.Minute * 30
+
+// exceptionTor is a type that stores Tor exit node addresses read from the Tor bulk exit list,
+// either from a URL or a local file. By default exit addresses are excepted; if Penalty is
+// set they are instead given a standing reputation penalty, see applyStandingPenalty.
+type exceptionTor struct {
+	Config   string
+	Path     string        // Local file to read the exit list from instead of a URL
+	Penalty  uint          // Standing penalty for exit addresses, or 0 to except them
+	Interval time.Duration // Polling interval
+
+	url *exceptionURL // Fetches the exit list if Path is not set
+}
+
+// newExceptionTor parses a Tor exception source configuration, which is a semicolon separated
+// list of key:value options (e.g., penalty:50;interval:1h). The url or file key selects where
+// the exit list is read from, and the penalty key selects the penalty mode.
+func newExceptionTor(config string) (*exceptionTor, error) {
+	ret := &exceptionTor{
+		Config:   config,
+		Interval: torUpdateInterval,
+	}
+	listURL := torExitListURL
+	if config != "" {
+		for _, f := range strings.Split(config, ";") {
+			tmp := strings.SplitN(f, ":", 2)
+			if len(tmp) != 2 || tmp[1] == "" {
+				return nil, fmt.Errorf("Invalid Tor exception option %q (format should be key:value)", f)
+			}
+			switch tmp[0] {
+			case "url":
+				listURL = tmp[1]
+			case "file":
+				ret.Path = tmp[1]
+			case "penalty":
+				penalty, err := strconv.ParseUint(tmp[1], 10, 64)
+				if err != nil || penalty == 0 || !IsValidViolationPenalty(uint(penalty)) {
+					return nil, fmt.Errorf("Invalid Tor exception penalty %q (must be 1 to 100)", tmp[1])
+				}
+				ret.Penalty = uint(penalty)
+			case "interval":
+				var err error
+				ret.Interval, err = time.ParseDuration(tmp[1])
+				if err != nil || ret.Interval <= 0 {
+					return nil, fmt.Errorf("Invalid Tor exception interval %q", tmp[1])
+				}
+			default:
+				return nil, fmt.Errorf("Invalid Tor exception option key %q", tmp[0])
+			}
+		}
+	}
+	if ret.Path != "" && listURL != torExitListURL {
+		return nil, fmt.Errorf("Tor exception source can have a url or a file, not both")
+	}
+	if ret.Path == "" {
+		var err error
+		ret.url, err = newExceptionURL(listURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (e *exceptionTor) getName() string {
+	return e.getCreatorPrefix() + ":" + e.Config
+}
+
+func (e *exceptionTor) getCreatorPrefix() string {
+	return "tor"
+}
+
+func (e *exceptionTor) isStatic() bool {
+	return false
+}
+
+func (e *exceptionTor) updateInterval() *time.Duration {
+	ret := e.Interval
+	return &ret
+}
+
+func (e *exceptionTor) standingPenalty() uint {
+	return e.Penalty
+}
+
+func (e *exceptionTor) getExceptions() (ret []ExceptionEntry, err error) {
+	var cidrs []string
+	if e.Path != "" {
+		var buf []byte
+		buf, err = ioutil.ReadFile(e.Path)
+		if err != nil {
+			return
+		}
+		for _, a := range exceptionTextAddresses(buf) {
+			cidr, err := NormalizeCIDROrIP(a)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid address %q in %s", a, e.Path)
+			}
+			cidrs = append(cidrs, cidr)
+		}
+	} else {
+		cidrs, err = e.url.fetch()
+		if err != nil {
+			return
+		}
+	}
+	expires := exceptionCalcExpiry(e)
+	for _, cidr := range cidrs {
+		ret = append(ret, ExceptionEntry{
+			Creator: e.getName(),
+			IP:      cidr,
+			Expires: expires,
+		})
+	}
+	return ret, nil
+}
+
+// applyStandingPenalty caps the reputation of each address in entries at 100 - penalty. It is
+// applied on every update rather than once, so the addresses stay penalized while they are
+// listed even as their reputation recovers through decay, and recover normally once they are
+// no longer listed. Excepted addresses are not penalized.
+func applyStandingPenalty(entries []ExceptionEntry, penalty uint) error {
+	var ips []string
+	for _, v := range entries {
+		ips = append(ips, v.IP)
+	}
+	n, err := db.CapReputations(nil, ips, 100-penalty)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"addresses": len(ips),
+		"penalized": n,
+	}).Infof("Applied standing penalty %d", penalty)
+	return nil
+}
+
+// AddTorException adds a new exception source that periodically reads the Tor bulk exit list,
+// see newExceptionTor for the configuration format
+func AddTorException(config string) error {
+	e, err := newExceptionTor(config)
+	if err != nil {
+		return err
+	}
+	exceptionSources = append(exceptionSources, e)
+	return nil
+}
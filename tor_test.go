@@ -0,0 +1,129 @@
+package tigerblood
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+const testTorExitList = "171.25.193.20\n185.220.101.1\n2001:67c:e60:c0c:192:42:116:16\n"
+
+func TestTorExceptionConfig(t *testing.T) {
+	e, err := newExceptionTor("")
+	assert.Nil(t, err)
+	assert.Equal(t, torExitListURL, e.url.URL)
+	assert.Equal(t, uint(0), e.standingPenalty())
+	assert.Equal(t, torUpdateInterval, *e.updateInterval())
+	assert.Equal(t, "tor:", e.getName())
+
+	e, err = newExceptionTor("file:/etc/tor/exits.txt;penalty:40;interval:10m")
+	assert.Nil(t, err)
+	assert.Nil(t, e.url)
+	assert.Equal(t, "/etc/tor/exits.txt", e.Path)
+	assert.Equal(t, uint(40), e.standingPenalty())
+	assert.Equal(t, 10*time.Minute, *e.updateInterval())
+
+	_, err = newExceptionTor("penalty:0")
+	assert.NotNil(t, err)
+	_, err = newExceptionTor("penalty:101")
+	assert.NotNil(t, err)
+	_, err = newExceptionTor("mode:exempt")
+	assert.NotNil(t, err)
+	_, err = newExceptionTor("file:/tmp/exits.txt;url:https://example.com/exits")
+	assert.NotNil(t, err)
+}
+
+func TestTorExceptionSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testTorExitList))
+	}))
+	defer srv.Close()
+
+	e, err := newExceptionTor("url:" + srv.URL)
+	assert.Nil(t, err)
+	ret, err := e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ret))
+	assert.Equal(t, "171.25.193.20/32", ret[0].IP)
+	assert.Equal(t, "2001:67c:e60:c0c:192:42:116:16/128", ret[2].IP)
+	assert.Equal(t, "tor:url:"+srv.URL, ret[0].Creator)
+	assert.False(t, ret[0].Expires.IsZero())
+
+	f, err := ioutil.TempFile("", "torexits")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# exits\n" + testTorExitList)
+	f.Close()
+	e, err = newExceptionTor("file:" + f.Name())
+	assert.Nil(t, err)
+	ret, err = e.getExceptions()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ret))
+}
+
+func TestTorStandingPenalty(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+
+	f, err := ioutil.TempFile("", "torexits")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(testTorExitList)
+	f.Close()
+
+	// An already lower reputation is left alone, and excepted addresses are skipped
+	_, err = db.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "185.220.101.1", Reputation: 10})
+	assert.Nil(t, err)
+	assert.Nil(t, db.InsertOrUpdateExceptionEntry(nil, ExceptionEntry{
+		IP:      "2001:67c:e60::/48",
+		Creator: "file:/test",
+	}))
+
+	e, err := newExceptionTor("file:" + f.Name() + ";penalty:40")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, updateExceptionSource(e))
+
+	entry, err := db.SelectSmallestMatchingSubnet("171.25.193.20")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(60), entry.Reputation)
+	entry, err = db.SelectSmallestMatchingSubnet("185.220.101.1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(10), entry.Reputation)
+	_, err = db.SelectSmallestMatchingSubnet("2001:67c:e60:c0c:192:42:116:16")
+	assert.NotNil(t, err)
+
+	// No exceptions are added in penalty mode
+	ret, err := db.SelectExceptionsContaining("171.25.193.20")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ret))
+
+	// Recovered addresses are capped again on the next update
+	_, err = db.InsertOrUpdateReputationEntry(nil, ReputationEntry{IP: "171.25.193.20", Reputation: 90})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, updateExceptionSource(e))
+	entry, err = db.SelectSmallestMatchingSubnet("171.25.193.20")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(60), entry.Reputation)
+
+	// In exempt mode the exit addresses are excepted
+	e, err = newExceptionTor("file:" + f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, updateExceptionSource(e))
+	ret, err = db.SelectExceptionsContaining("171.25.193.20")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ret))
+	assert.Equal(t, "tor:file:"+f.Name(), ret[0].Creator)
+
+	exceptionStatusLock.Lock()
+	exceptionStatus = make(map[string]*ExceptionSourceStatus)
+	exceptionStatusLock.Unlock()
+	assert.Nil(t, db.Close())
+}
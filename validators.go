@@ -42,6 +42,15 @@ func IsValidViolationName(name string) bool {
 	return violationRegex.MatchString(name)
 }
 
+// exportNameRegex allows names that are valid both as ipset set names, leaving room for the
+// IPv6 set suffix, and as AWS WAF IP set names
+var exportNameRegex = regexp.MustCompile(`^[\w-]{1,30}$`)
+
+// IsValidExportName checks if an export set name matches [\w-]{1,30}
+func IsValidExportName(name string) bool {
+	return exportNameRegex.MatchString(name)
+}
+
 // IsValidReputationEntry checks if a ReputationEntry has valid IP and reputation fields
 func IsValidReputationEntry(entry ReputationEntry) bool {
 	return IsValidReputationCIDROrIP(entry.IP) && IsValidReputation(entry.Reputation)
@@ -68,13 +77,14 @@ func ValidateIPViolationEntryAndGetPenalty(entry IPViolationEntry) (uint, Errno)
 		return 0, InvalidViolationTypeError
 	}
 
-	if violationPenalties == nil {
+	penalties, _ := currentViolationPenalties()
+	if penalties == nil {
 		log.WithFields(log.Fields{"errno": MissingViolations}).Warnf(DescribeErrno(MissingViolations))
 		return 0, MissingViolations
 	}
 
-	// lookup violation weight in config map
-	var penalty, ok = violationPenalties[entry.Violation]
+	// lookup violation weight in the catalog
+	var penalty, ok = penalties[entry.Violation]
 	if !ok {
 		log.WithFields(log.Fields{
 			"errno": MissingViolationTypeError,
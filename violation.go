@@ -0,0 +1,193 @@
+package tigerblood
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// ViolationType is an entry in the violation catalog. Only enabled violation types can be
+// applied to reputations.
+type ViolationType struct {
+	Name        string    // The violation type name
+	Penalty     uint      // The reputation penalty applied for the violation
+	Description string    // Human readable description of the violation
+	Enabled     bool      // False if the violation type is rejected by the violation endpoints
+	Modified    time.Time // When the violation type was last changed
+}
+
+// SelectViolationTypes returns the violation catalog ordered by name
+func (db DB) SelectViolationTypes() (ret []ViolationType, err error) {
+	rows, err := db.Query("SELECT name, penalty, description, enabled, modified FROM violation " +
+		"ORDER BY name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v ViolationType
+		err = rows.Scan(&v.Name, &v.Penalty, &v.Description, &v.Enabled, &v.Modified)
+		if err != nil {
+			return
+		}
+		ret = append(ret, v)
+	}
+	err = rows.Err()
+	return
+}
+
+// SelectViolationType returns the catalog entry for the violation type name, or nil if there
+// is no such violation type
+func (db DB) SelectViolationType(name string) (*ViolationType, error) {
+	v := ViolationType{Name: name}
+	err := db.QueryRow("SELECT penalty, description, enabled, modified FROM violation WHERE name = $1",
+		name).Scan(&v.Penalty, &v.Description, &v.Enabled, &v.Modified)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// InsertViolationType adds a violation type to the catalog. It returns a DuplicateKeyError
+// if the violation type already exists.
+func (db DB) InsertViolationType(tx *sql.Tx, v ViolationType) error {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	_, err := exec("INSERT INTO violation (name, penalty, description, enabled) VALUES ($1, $2, $3, $4)",
+		v.Name, v.Penalty, v.Description, v.Enabled)
+	if pqErr, ok := err.(*pq.Error); ok {
+		if pqErr.Code == pgDuplicateKeyErrorCode {
+			return DuplicateKeyError{pqErr}
+		}
+		if pqErr.Code == pgCheckViolationErrorCode {
+			return CheckViolationError{pqErr}
+		}
+	}
+	return err
+}
+
+// UpdateViolationType replaces the penalty, description and enabled flag of a violation type
+// in the catalog. It returns ErrNoRowsAffected if the violation type does not exist.
+func (db DB) UpdateViolationType(tx *sql.Tx, v ViolationType) error {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("UPDATE violation SET penalty = $2, description = $3, enabled = $4, "+
+		"modified = now() WHERE name = $1", v.Name, v.Penalty, v.Description, v.Enabled)
+	if pqErr, ok := err.(*pq.Error); ok {
+		if pqErr.Code == pgCheckViolationErrorCode {
+			return CheckViolationError{pqErr}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// DeleteViolationType removes a violation type from the catalog. It returns ErrNoRowsAffected
+// if the violation type does not exist.
+func (db DB) DeleteViolationType(tx *sql.Tx, name string) error {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	res, err := exec("DELETE FROM violation WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// SeedViolationTypes adds an enabled violation type to the catalog for each entry in
+// penalties that is not already in the catalog. Existing violation types are left unchanged,
+// so changes made through the API are kept across restarts.
+func (db DB) SeedViolationTypes(tx *sql.Tx, penalties map[string]uint) error {
+	exec := db.Exec
+	if tx != nil {
+		exec = tx.Exec
+	}
+	for name, penalty := range penalties {
+		_, err := exec("INSERT INTO violation (name, penalty) VALUES ($1, $2) "+
+			"ON CONFLICT (name) DO NOTHING", name, penalty)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeedViolationCatalog seeds the violation catalog with penalties, see SeedViolationTypes
+func SeedViolationCatalog(penalties map[string]uint) error {
+	for name, penalty := range penalties {
+		if !IsValidViolationName(name) {
+			return fmt.Errorf("Invalid violation type: %s", name)
+		}
+		if !IsValidViolationPenalty(penalty) {
+			return fmt.Errorf("Invalid violation penalty: %s: %d", name, penalty)
+		}
+	}
+	return db.SeedViolationTypes(nil, penalties)
+}
+
+// LoadViolationTypes reads the violation catalog and sets the violation penalties used by
+// the violation endpoints to its enabled violation types
+func LoadViolationTypes() error {
+	types, err := db.SelectViolationTypes()
+	if err != nil {
+		return err
+	}
+	penalties := make(map[string]uint)
+	for _, v := range types {
+		if !v.Enabled {
+			continue
+		}
+		if !IsValidViolationName(v.Name) || !IsValidViolationPenalty(v.Penalty) {
+			log.WithFields(log.Fields{"errno": InvalidViolationTypeError}).Warnf(
+				DescribeErrno(InvalidViolationTypeError), v.Name)
+			continue
+		}
+		penalties[v.Name] = v.Penalty
+	}
+	SetViolationPenalties(penalties)
+	return nil
+}
+
+// StartViolationTypeRefresh starts a routine that reloads the violation catalog every
+// interval, so that changes made through another replica take effect without a restart
+func StartViolationTypeRefresh(interval time.Duration) {
+	if interval <= 0 {
+		log.Fatalf("Invalid violation refresh interval: %s", interval)
+	}
+	go func() {
+		log.Printf("Starting violation catalog refresh routine (every %s)", interval)
+		for {
+			time.Sleep(interval)
+			err := LoadViolationTypes()
+			if err != nil {
+				log.WithFields(log.Fields{"errno": DBError}).Warnf("Error refreshing violation catalog: %s", err)
+			}
+		}
+	}()
+}
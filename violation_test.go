@@ -1,6 +1,8 @@
 package tigerblood
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
@@ -8,6 +10,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestListViolations(t *testing.T) {
@@ -293,3 +296,149 @@ func TestMultiInsertReputationByViolationRequiresDB(t *testing.T) {
 		strings.NewReader(`[{"ip": "192.168.0.1", "Violation": "TestViolation"}]`)))
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
+
+func TestViolationHistory(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	err = db.EmptyTables()
+	assert.Nil(t, err)
+
+	testViolations := map[string]uint{
+		"Test:Violation":  10,
+		"Test:Violation2": 20,
+	}
+
+	SetDB(db)
+	SetMaxEntries(100)
+	SetViolationPenalties(testViolations)
+	SetAPIKeyCredentials(map[string]string{"reporter": "valid_key"})
+	assert.Nil(t, SetCredentialScopes(map[string]string{"reporter": "read,violate"}))
+	defer SetCredentialScopes(nil)
+	SetAuthMask(AuthEnableAPIKey)
+	defer SetAuthMask(0)
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{RequireAuth()})
+	for _, v := range []string{"Test:Violation", "Test:Violation2", "Test:Violation"} {
+		recorder := httptest.ResponseRecorder{}
+		req := httptest.NewRequest("PUT", "/violations/192.168.0.1",
+			strings.NewReader(`{"Violation": "`+v+`"}`))
+		req.Header.Set("Authorization", "APIKey valid_key")
+		h.ServeHTTP(&recorder, req)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	}
+
+	type historyPage struct {
+		Violations []ViolationEvent
+		Next       int64
+	}
+	get := func(path string) (int, historyPage) {
+		var page historyPage
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "APIKey valid_key")
+		h.ServeHTTP(recorder, req)
+		if recorder.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		}
+		return recorder.Code, page
+	}
+
+	code, page := get("/violations/192.168.0.1?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, len(page.Violations))
+	assert.NotEqual(t, int64(0), page.Next)
+	assert.Equal(t, "Test:Violation", page.Violations[0].Violation)
+	assert.Equal(t, uint(60), page.Violations[0].Reputation)
+	assert.Equal(t, "reporter", page.Violations[0].Credential)
+	assert.Equal(t, "Test:Violation2", page.Violations[1].Violation)
+	assert.Equal(t, uint(20), page.Violations[1].Penalty)
+	assert.Equal(t, uint(70), page.Violations[1].Reputation)
+
+	code, page = get(fmt.Sprintf("/violations/192.168.0.1?limit=2&before=%d", page.Next))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, len(page.Violations))
+	assert.Equal(t, int64(0), page.Next)
+	assert.Equal(t, uint(90), page.Violations[0].Reputation)
+
+	code, page = get("/violations/192.168.0.0/24")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, len(page.Violations))
+
+	code, page = get("/violations/10.0.0.1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(page.Violations))
+
+	code, _ = get("/violations/192.168.0.1?limit=1000")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/violations/192.168.0.1?before=abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	n, err := db.DeleteViolationEventsBefore(nil, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	code, page = get("/violations/192.168.0.1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(page.Violations))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestViolationTypeCatalog(t *testing.T) {
+	dsn, found := os.LookupEnv("TIGERBLOOD_DSN")
+	assert.True(t, found)
+	db, err := NewDB(dsn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.EmptyTables())
+	SetDB(db)
+	defer SetViolationPenalties(nil)
+
+	assert.Nil(t, SeedViolationCatalog(map[string]uint{"Seeded": 30}))
+	assert.Nil(t, LoadViolationTypes())
+
+	h := HandleWithMiddleware(NewRouter(), []Middleware{})
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+	penalties := func() string {
+		return do("GET", "/violations", "").Body.String()
+	}
+	assert.Equal(t, `{"Seeded":30}`, penalties())
+
+	assert.Equal(t, http.StatusCreated, do("POST", "/violations/types/test:new",
+		`{"Penalty": 40, "Description": "a new violation"}`).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/violations/types/test:new", `{"Penalty": 10}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/violations/types/test:bad", `{"Penalty": 101}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/violations/types/bad!name", `{"Penalty": 1}`).Code)
+	assert.Equal(t, `{"Seeded":30,"test:new":40}`, penalties())
+
+	assert.Equal(t, http.StatusOK, do("PUT", "/violations/types/test:new", `{"Enabled": false}`).Code)
+	assert.Equal(t, `{"Seeded":30}`, penalties())
+	assert.Equal(t, http.StatusNotFound, do("PUT", "/violations/types/missing", `{"Penalty": 1}`).Code)
+
+	var types []ViolationType
+	recorder := do("GET", "/violations/types", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &types))
+	assert.Equal(t, 2, len(types))
+	assert.Equal(t, "Seeded", types[0].Name)
+	assert.True(t, types[0].Enabled)
+	assert.Equal(t, "test:new", types[1].Name)
+	assert.Equal(t, uint(40), types[1].Penalty)
+	assert.Equal(t, "a new violation", types[1].Description)
+	assert.False(t, types[1].Enabled)
+
+	// Seeding again leaves changes made through the API in place
+	assert.Nil(t, SeedViolationCatalog(map[string]uint{"Seeded": 50, "test:new": 50}))
+	assert.Nil(t, LoadViolationTypes())
+	assert.Equal(t, `{"Seeded":30}`, penalties())
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/violations/types/Seeded", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/violations/types/Seeded", "").Code)
+	assert.Equal(t, `{}`, penalties())
+
+	assert.Nil(t, db.Close())
+}